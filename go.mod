@@ -3,7 +3,9 @@ module github.com/Chaitanya-Shahare/chirpy
 go 1.22.1
 
 require (
-	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/crypto v0.23.0 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.23.0
 )
+
+require golang.org/x/sys v0.20.0 // indirect
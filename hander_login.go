@@ -2,15 +2,22 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// jwtIssuer and jwtAudience are set on every access token and required
+	// when validating one
+	jwtIssuer   = "chirpy"
+	jwtAudience = "chirpy-api"
 )
 
 type User struct {
@@ -18,15 +25,22 @@ type User struct {
 	Password         string `json:"password"`
 	ExpiresInSeconds int    `json:"expires_in_seconds"`
 	RefreshToken     string `json:"refresh_token"`
+	DeviceLabel      string `json:"device_label"`
+}
+
+// Claims are the JWT claims Chirpy issues. SessionID ties an access token to
+// the refresh token family it was minted from.
+type Claims struct {
+	jwt.RegisteredClaims
+	SessionID int `json:"sid,omitempty"`
 }
 
 // handlerLogin handles user login and JWT generation
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	var u User
 
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&u); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+	if err := cfg.decodeJSON(w, r, &u); err != nil {
+		respondWithDecodeError(w, err)
 		return
 	}
 
@@ -40,39 +54,89 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.DB.GetUserByEmail(u.Email)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid email or password")
+	if !cfg.checkLoginThrottle(w, r, u.Email) {
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(u.Password))
-	if err != nil {
+	// Unknown emails are checked against a dummy hash so they take as long
+	// as wrong passwords
+	passwordHash := cfg.dummyPasswordHash
+	user, err := cfg.DB.GetUserByEmail(u.Email)
+	if err == nil {
+		passwordHash = user.Password
+	}
+
+	err = cfg.passwords.Verify(u.Password, passwordHash)
+	if err != nil || user.ID == 0 {
+		cfg.recordLoginFailure(r, u.Email)
+		cfg.metrics.logins.With("failure").Inc()
 		respondWithError(w, http.StatusUnauthorized, "Invalid email or password")
 		return
 	}
 
-	userIDStr := strconv.Itoa(user.ID)
+	// Move old hashes to the current algorithm and parameters while we have
+	// the plaintext
+	if cfg.passwords.NeedsRehash(user.Password) {
+		if newHash, err := cfg.passwords.Hash(u.Password); err == nil {
+			err = cfg.DB.UpdateUserPassword(user.ID, newHash)
+			if err != nil {
+				cfg.requestLogger(r).Error("Couldn't rehash password", "err", err)
+			}
+		}
+	}
 
-	tokenString, err := cfg.createJWT(userIDStr, 3600) // Access token expires in 1 hour
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error creating JWT")
+	if user.Suspended {
+		respondWithError(w, http.StatusForbidden, "Account suspended")
 		return
 	}
 
+	// Users with two-factor authentication get a challenge to exchange
+	// together with a code at /api/login/2fa
+	if user.TwoFactor.Enabled {
+		challengeToken, err := cfg.createChallengeToken(user.ID, u.DeviceLabel)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error creating challenge token")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			ChallengeToken    string `json:"challenge_token"`
+		}{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+		})
+		return
+	}
+
+	cfg.clearLoginFailures(u.Email)
+	cfg.respondWithLoginTokens(w, r, user, u.DeviceLabel)
+}
+
+// respondWithLoginTokens starts a new session for the user and responds with
+// its access and refresh tokens
+func (cfg *apiConfig) respondWithLoginTokens(w http.ResponseWriter, r *http.Request, user database.User, deviceLabel string) {
 	refreshToken, err := cfg.createRefreshToken()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error creating refresh token")
 		return
 	}
 
-	// Store the refresh token with the user in the database
-	err = cfg.DB.UpdateUserRefreshToken(user.ID, refreshToken)
+	// Store only the hash of the refresh token, starting a new session
+	session, err := cfg.DB.CreateRefreshToken(user.ID, hashToken(refreshToken), time.Now().UTC().Add(cfg.config.RefreshTokenTTL), clientInfo(r, deviceLabel))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error storing refresh token")
 		return
 	}
 
+	userIDStr := strconv.Itoa(user.ID)
+
+	tokenString, err := cfg.createJWT(userIDStr, session.FamilyID, cfg.config.AccessTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating JWT")
+		return
+	}
+	cfg.metrics.logins.With("success").Inc()
+
 	respondWithJSON(w, http.StatusOK, struct {
 		Email        string `json:"email"`
 		ID           int    `json:"id"`
@@ -84,24 +148,32 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		ID:           user.ID,
 		Token:        tokenString,
 		RefreshToken: refreshToken,
-		IsChirpyRed:  user.IsChirpyRed,
+		IsChirpyRed:  user.IsChirpyRed(),
 	})
 }
 
-// createJWT creates a new JWT for the given user ID and expiration time
-func (cfg *apiConfig) createJWT(userID string, expiresInSeconds int) (string, error) {
-	expirationTime := time.Duration(expiresInSeconds) * time.Second
+// createJWT creates a new JWT for the given user ID and session that expires
+// after expiresIn
+func (cfg *apiConfig) createJWT(userID string, sessionID int, expiresIn time.Duration) (string, error) {
 
-	claims := &jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expirationTime)),
-		Subject:   userID,
+	jtiBytes := make([]byte, 16)
+	if _, err := rand.Read(jtiBytes); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{jwtAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID,
+			ID:        hex.EncodeToString(jtiBytes),
+		},
+		SessionID: sessionID,
+	}
 
-	tokenString, err := token.SignedString([]byte(cfg.jwtSecret))
+	tokenString, err := cfg.jwtKeys.Sign(claims)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign the token: %w", err)
 	}
@@ -119,3 +191,10 @@ func (cfg *apiConfig) createRefreshToken() (string, error) {
 	refreshToken := hex.EncodeToString(refreshTokenBytes)
 	return refreshToken, nil
 }
+
+// hashToken returns the hex-encoded SHA-256 hash under which refresh and
+// personal access tokens are stored
+func hashToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
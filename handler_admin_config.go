@@ -0,0 +1,16 @@
+package main
+
+import (
+	"net/http"
+)
+
+// handlerAdminConfig responds with the configuration the server is running
+// with, secrets redacted.
+func (cfg *apiConfig) handlerAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.config.Redacted())
+}
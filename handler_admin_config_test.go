@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminConfigRedactsSecrets(t *testing.T) {
+	cfg, _ := newTestAPIConfig(t)
+	cfg.adminAPIKey = "admin-key"
+	cfg.config.AdminAPIKey = "admin-key"
+	cfg.config.JWTSecret = "jwt-secret-value"
+	cfg.config.TOTPEncryptionKey = "dG90cC1lbmNyeXB0aW9uLWtleS10b3RwLWtleS0xMjM="
+	cfg.config.PolkaWebhookSecrets = []string{"polka-old", "polka-new"}
+	cfg.config.SMTPPassword = "smtp-password"
+
+	get := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/admin/config", nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		cfg.handlerAdminConfig(rec, req)
+		return rec
+	}
+
+	if rec := get("ApiKey wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong key: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec := get("ApiKey admin-key")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	for _, secret := range []string{"admin-key", "jwt-secret-value", "dG90cC1", "polka-old", "polka-new", "smtp-password"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Errorf("response contains the secret %q", secret)
+		}
+	}
+
+	var settings map[string]any
+	decodeBody(t, rec, &settings)
+	for _, name := range []string{"admin_api_key", "jwt_secret", "totp_encryption_key", "polka_webhook_secrets", "smtp_password"} {
+		if settings[name] != "REDACTED" {
+			t.Errorf("%s = %v, want REDACTED", name, settings[name])
+		}
+	}
+	if settings["port"] != float64(8080) {
+		t.Errorf("port = %v, want 8080", settings["port"])
+	}
+}
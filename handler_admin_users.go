@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+// handlerAdminUsersSuspend blocks a user from logging in and revokes every
+// token they hold.
+func (cfg *apiConfig) handlerAdminUsersSuspend(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = cfg.DB.SuspendUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't suspend user")
+		return
+	}
+	cfg.notify(database.Notification{
+		UserID: userID,
+		Type:   database.NotificationModeration,
+		Detail: "suspended",
+	})
+	// Suspending revokes the user's tokens; the notice above is still sent
+	// before their sockets close
+	cfg.notificationHub.disconnect(userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeAdmin checks the request's "ApiKey" authorization against
+// ADMIN_API_KEY. Admin actions are disabled while no key is configured.
+func (cfg *apiConfig) authorizeAdmin(r *http.Request) bool {
+	if cfg.adminAPIKey == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	apiKey := strings.TrimPrefix(auth, "ApiKey ")
+	if apiKey == auth {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminAPIKey)) == 1
+}
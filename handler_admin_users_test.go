@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizeAdmin(t *testing.T) {
+	cfg, _ := newTestAPIConfig(t)
+
+	tests := []struct {
+		key  string
+		auth string
+		want bool
+	}{
+		{"admin-key", "ApiKey admin-key", true},
+		{"admin-key", "admin-key", false},
+		{"admin-key", "Bearer admin-key", false},
+		{"admin-key", "apikey admin-key", false},
+		{"admin-key", "ApiKey admin-key2", false},
+		{"admin-key", "", false},
+		// Admin actions are disabled while no key is configured
+		{"", "ApiKey ", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		cfg.adminAPIKey = tt.key
+		req := httptest.NewRequest("GET", "/admin/config", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		if got := cfg.authorizeAdmin(req); got != tt.want {
+			t.Errorf("key %q, Authorization %q: authorized = %v, want %v", tt.key, tt.auth, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+const (
+	defaultWebhookPageSize = 50
+	maxWebhookPageSize     = 200
+)
+
+// WebhookDelivery is a received webhook event as shown to admins
+type WebhookDelivery struct {
+	ID            int             `json:"id"`
+	Source        string          `json:"source"`
+	EventID       string          `json:"event_id"`
+	Type          string          `json:"type"`
+	Status        string          `json:"status"`
+	Error         string          `json:"error,omitempty"`
+	Attempts      int             `json:"attempts"`
+	Payload       json.RawMessage `json:"payload"`
+	ReceivedAt    time.Time       `json:"received_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at,omitempty"`
+	ProcessedAt   *time.Time      `json:"processed_at,omitempty"`
+}
+
+// handlerAdminWebhooksList lists webhook deliveries, newest first. They can
+// be filtered by status and paged through with limit and before, the
+// smallest ID of the previous page.
+func (cfg *apiConfig) handlerAdminWebhooksList(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", database.WebhookPending, database.WebhookProcessing, database.WebhookProcessed, database.WebhookIgnored, database.WebhookFailed:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	cfg.respondWithWebhookDeliveries(w, r, status)
+}
+
+// handlerAdminWebhooksDeadLetter lists the deliveries whose processing
+// failed and that haven't succeeded since.
+func (cfg *apiConfig) handlerAdminWebhooksDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	cfg.respondWithWebhookDeliveries(w, r, database.WebhookFailed)
+}
+
+func (cfg *apiConfig) handlerAdminWebhooksGet(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	event, err := cfg.DB.GetWebhookEvent(id)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Delivery not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve delivery")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newWebhookDelivery(event))
+}
+
+// handlerAdminWebhooksReplay processes a recorded event again. Events that
+// were processed or ignored are only applied again with force=true, since
+// applying an upgrade twice extends the subscription twice. It responds with
+// the delivery as updated by the new attempt.
+func (cfg *apiConfig) handlerAdminWebhooksReplay(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	force := false
+	if v := r.URL.Query().Get("force"); v != "" {
+		force, err = strconv.ParseBool(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid force")
+			return
+		}
+	}
+
+	event, err := cfg.DB.GetWebhookEvent(id)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Delivery not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve delivery")
+		return
+	}
+	if event.Source != polkaSource {
+		respondWithError(w, http.StatusBadRequest, "Can't replay events from "+event.Source)
+		return
+	}
+
+	event, err = cfg.DB.ClaimWebhookEvent(event.ID, force)
+	if errors.Is(err, database.ErrWebhookEventDone) {
+		respondWithError(w, http.StatusConflict, "Delivery was already processed; replay with force=true to apply it again")
+		return
+	}
+	if errors.Is(err, database.ErrWebhookEventBusy) {
+		respondWithError(w, http.StatusConflict, "Delivery is being processed")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't claim delivery")
+		return
+	}
+
+	// A processing failure is recorded on the delivery, which is what the
+	// admin wants to see, so only a failure to record it is an error here
+	event, err = cfg.processPolkaEvent(event)
+	if errors.Is(err, errRecordWebhookEvent) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record replay")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newWebhookDelivery(event))
+}
+
+func (cfg *apiConfig) respondWithWebhookDeliveries(w http.ResponseWriter, r *http.Request, status string) {
+	limit := defaultWebhookPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxWebhookPageSize {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxWebhookPageSize))
+			return
+		}
+		limit = n
+	}
+
+	before := 0
+	if v := r.URL.Query().Get("before"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid before")
+			return
+		}
+		before = n
+	}
+
+	events, err := cfg.DB.GetWebhookEvents(status, before, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve deliveries")
+		return
+	}
+
+	deliveries := make([]WebhookDelivery, 0, len(events))
+	for _, event := range events {
+		deliveries = append(deliveries, newWebhookDelivery(event))
+	}
+
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+func newWebhookDelivery(event database.WebhookEvent) WebhookDelivery {
+	return WebhookDelivery{
+		ID:            event.ID,
+		Source:        event.Source,
+		EventID:       event.EventID,
+		Type:          event.Type,
+		Status:        event.Status,
+		Error:         event.Error,
+		Attempts:      event.Attempts,
+		Payload:       event.Payload,
+		ReceivedAt:    event.ReceivedAt,
+		LastAttemptAt: event.LastAttemptAt,
+		ProcessedAt:   event.ProcessedAt,
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"image"
+	"net/http"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/imaging"
+)
+
+// handlerAvatarUpload sets the caller's avatar from an image sent as the
+// "file" field of a multipart form. It is cropped to a square.
+func (cfg *apiConfig) handlerAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	data, err := readUpload(w, r, maxAvatarBytes)
+	if err != nil {
+		respondWithUploadError(w, err, maxAvatarBytes)
+		return
+	}
+
+	img, err := cfg.storeImage(data, func(img *image.NRGBA) (*image.NRGBA, *image.NRGBA) {
+		return imaging.Square(img, avatarSize), imaging.Square(img, avatarThumbnailSize)
+	})
+	if err != nil {
+		respondWithUploadError(w, err, maxAvatarBytes)
+		return
+	}
+
+	previous, err := cfg.DB.SetUserAvatar(caller.ID, &img)
+	if err != nil {
+		cfg.deleteImage(img)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save avatar")
+		return
+	}
+	if previous != nil {
+		cfg.deleteImage(*previous)
+	}
+
+	user, err := cfg.DB.GetUserByID(caller.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newUserProfile(user))
+}
+
+func (cfg *apiConfig) handlerAvatarDelete(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	previous, err := cfg.DB.SetUserAvatar(caller.ID, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove avatar")
+		return
+	}
+	if previous != nil {
+		cfg.deleteImage(*previous)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,77 +1,149 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
 	// "github.com/golang-jwt/jwt/v5"
 )
 
+// unverifiedChirpsPerDay limits how much accounts with an unverified email
+// can post
+const unverifiedChirpsPerDay = 3
+
 type Chirp struct {
-	ID       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorID int    `json:"author_id"`
+	ID       int          `json:"id"`
+	Body     string       `json:"body"`
+	AuthorID int          `json:"author_id"`
+	Author   *ChirpAuthor `json:"author,omitempty"`
+	Media    []Media      `json:"media"`
+}
+
+// ChirpAuthor is the compact public view of a user embedded in chirps
+type ChirpAuthor struct {
+	ID          int    `json:"id"`
+	Handle      string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+	// AvatarURL points at the avatar's thumbnail
+	AvatarURL string `json:"avatar_url,omitempty"`
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
-	auth := r.Header.Get("Authorization")
-	tokenString := strings.TrimPrefix(auth, "Bearer ")
+	user, err := cfg.authenticate(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
 
-	claims, err := cfg.validateJWT(tokenString)
+	author, err := cfg.DB.GetUserByID(user.ID)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Invalid token")
 		return
 	}
+	if !author.EmailVerified {
+		count, err := cfg.DB.CountChirpsByAuthorSince(author.ID, time.Now().UTC().Add(-24*time.Hour))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check chirp limit")
+			return
+		}
+		if count >= unverifiedChirpsPerDay {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("Verify your email to post more than %d chirps a day", unverifiedChirpsPerDay))
+			return
+		}
+	}
 
 	type parameters struct {
-		Body string `json:"body"`
+		Body     string `json:"body"`
+		MediaIDs []int  `json:"media_ids"`
 	}
 
-	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
 		return
 	}
 
-	cleaned, err := validateChirp(params.Body)
+	cleaned, err := cfg.validateChirp(params.Body)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	authorID, err := strconv.Atoi(claims.Subject)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't extract user ID")
+	if len(params.MediaIDs) > maxMediaPerChirp {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A chirp can have at most %d attachments", maxMediaPerChirp))
 		return
 	}
+	slices.Sort(params.MediaIDs)
+	params.MediaIDs = slices.Compact(params.MediaIDs)
 
-	chirp, err := cfg.DB.CreateChirp(cleaned, authorID)
+	chirp, err := cfg.DB.CreateChirp(cleaned, user.ID, params.MediaIDs)
+	if errors.Is(err, database.ErrMediaUnavailable) {
+		respondWithError(w, http.StatusBadRequest, "Media not found or already attached")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
+	cfg.metrics.chirpsCreated.Inc()
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
+	media, err := cfg.DB.GetMediaByChirpIDs([]int{chirp.ID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve media")
+		return
+	}
+
+	resp := newChirp(chirp, &author, media[chirp.ID])
+	cfg.emitEvent(eventChirpCreated, user.ID, resp)
+	cfg.publishChirpEvent(eventChirpCreated, user.ID, resp)
+	cfg.notifyMentions(chirp)
+
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+// newChirp builds the response for a chirp. The author is left out when it
+// is nil, e.g. because the account no longer exists.
+func newChirp(chirp database.Chirp, author *database.User, media []database.Media) Chirp {
+	resp := Chirp{
 		ID:       chirp.ID,
 		Body:     chirp.Body,
 		AuthorID: chirp.AuthorID,
-	})
+		Media:    []Media{},
+	}
+	if author != nil {
+		resp.Author = newChirpAuthor(*author)
+	}
+	for _, m := range media {
+		resp.Media = append(resp.Media, newMedia(m))
+	}
+	return resp
+}
+
+func newChirpAuthor(user database.User) *ChirpAuthor {
+	_, avatarThumbnailURL := avatarURLs(user)
+	return &ChirpAuthor{
+		ID:          user.ID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		IsChirpyRed: user.IsChirpyRed(),
+		AvatarURL:   avatarThumbnailURL,
+	}
 }
 
-func validateChirp(body string) (string, error) {
-	const maxChirpLength = 140
-	if len(body) > maxChirpLength {
+func (cfg *apiConfig) validateChirp(body string) (string, error) {
+	if len(body) > cfg.config.MaxChirpLength {
 		return "", errors.New("Chirp is too long")
 	}
 
-	badWords := map[string]struct{}{
-		"kerfuffle": {},
-		"sharbert":  {},
-		"fornax":    {},
+	badWords := map[string]struct{}{}
+	for _, word := range cfg.config.BadWords {
+		badWords[strings.ToLower(word)] = struct{}{}
 	}
 	cleaned := getCleanedBody(body, badWords)
 	return cleaned, nil
@@ -3,7 +3,6 @@ package main
 import (
 	"net/http"
 	"strconv"
-	"strings"
 )
 
 func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
@@ -17,33 +16,28 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 
 	chirp, err := cfg.DB.GetChirp(chirpID)
 
-	auth := r.Header.Get("Authorization")
-	tokenString := strings.TrimPrefix(auth, "Bearer ")
-
-	claims, err := cfg.validateJWT(tokenString)
-
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid token")
-		return
-	}
-
-	userID, err := strconv.Atoi(claims.Subject)
-
+	user, err := cfg.authenticate(r, scopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't extract user ID")
+		respondWithAuthError(w, err)
 		return
 	}
 
-	if chirp.AuthorID != userID {
+	if chirp.AuthorID != user.ID {
 		respondWithError(w, http.StatusForbidden, "You can't delete this chirp")
 		return
 	}
 
-	err = cfg.DB.DeleteChirp(chirpID)
+	media, err := cfg.DB.DeleteChirp(chirpID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp")
 		return
 	}
+	for _, m := range media {
+		cfg.deleteImage(m.Image)
+	}
+	deleted := map[string]int{"id": chirpID, "author_id": user.ID}
+	cfg.emitEvent(eventChirpDeleted, user.ID, deleted)
+	cfg.publishChirpEvent(eventChirpDeleted, user.ID, deleted)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -22,10 +22,18 @@ func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:   dbChirp.ID,
-		Body: dbChirp.Body,
-	})
+	var author *database.User
+	if user, err := cfg.DB.GetUserByID(dbChirp.AuthorID); err == nil {
+		author = &user
+	}
+
+	media, err := cfg.DB.GetMediaByChirpIDs([]int{dbChirp.ID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve media")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newChirp(dbChirp, author, media[dbChirp.ID]))
 }
 
 func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
@@ -61,14 +69,32 @@ func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Look up every author and attachment once rather than per chirp
+	authorIDs := make([]int, 0, len(dbChirps))
+	chirpIDs := make([]int, 0, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		authorIDs = append(authorIDs, dbChirp.AuthorID)
+		chirpIDs = append(chirpIDs, dbChirp.ID)
+	}
+	authors, err := cfg.DB.GetUsersByIDs(authorIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve authors")
+		return
+	}
+	media, err := cfg.DB.GetMediaByChirpIDs(chirpIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve media")
+		return
+	}
+
 	// Convert retrieved chirps into the desired format
 	chirps := []Chirp{}
 	for _, dbChirp := range dbChirps {
-		chirps = append(chirps, Chirp{
-			ID:       dbChirp.ID,
-			Body:     dbChirp.Body,
-			AuthorID: dbChirp.AuthorID,
-		})
+		var author *database.User
+		if user, ok := authors[dbChirp.AuthorID]; ok {
+			author = &user
+		}
+		chirps = append(chirps, newChirp(dbChirp, author, media[dbChirp.ID]))
 	}
 
 	// Sort chirps based on sortParam
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/stream"
+)
+
+// streamHeartbeatInterval is how often idle streams get a comment, so
+// proxies don't close them. Tests shorten it.
+var streamHeartbeatInterval = 15 * time.Second
+
+const (
+	// streamWriteTimeout drops clients that stop reading
+	streamWriteTimeout = 10 * time.Second
+	streamRetry        = 3 * time.Second
+	// eventStreamReset tells a resuming client that events it missed are no
+	// longer buffered, so it should refetch chirps
+	eventStreamReset = "stream.reset"
+)
+
+// publishChirpEvent sends an event to the clients streaming chirps.
+func (cfg *apiConfig) publishChirpEvent(eventType string, authorID int, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		cfg.logger.Error("Couldn't marshal chirp event", "type", eventType, "err", err)
+		return
+	}
+	cfg.chirpStream.Publish(eventType, authorID, payload)
+}
+
+// handlerChirpsStream streams chirp.created and chirp.deleted events as
+// server-sent events. They can be limited to one author with author_id, or
+// with timeline=true to the caller and the users they follow when the
+// stream starts. A client reconnecting with Last-Event-ID is first sent the
+// events it missed, or stream.reset if they are no longer buffered.
+func (cfg *apiConfig) handlerChirpsStream(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	authorIDString := query.Get("author_id")
+	timeline := query.Get("timeline") == "true"
+
+	var filter func(stream.Event) bool
+	switch {
+	case authorIDString != "" && timeline:
+		respondWithError(w, http.StatusBadRequest, "author_id can't be combined with timeline")
+		return
+	case authorIDString != "":
+		authorID, err := strconv.Atoi(authorIDString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
+			return
+		}
+		filter = func(event stream.Event) bool {
+			return event.AuthorID == authorID
+		}
+	case timeline:
+		user, err := cfg.authenticate(r, scopeChirpsRead)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		followeeIDs, err := cfg.DB.GetFolloweeIDs(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve followed users")
+			return
+		}
+		authors := map[int]bool{user.ID: true}
+		for _, id := range followeeIDs {
+			authors[id] = true
+		}
+		filter = func(event stream.Event) bool {
+			return authors[event.AuthorID]
+		}
+	}
+
+	var lastEventID uint64
+	resume := false
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+		lastEventID, resume = id, true
+	}
+
+	sub, missed, complete := cfg.chirpStream.Subscribe(filter, resume, lastEventID)
+	defer cfg.chirpStream.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// The server's read and write timeouts are meant for ordinary requests;
+	// without lifting the read deadline the request would be cancelled
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	write := func(format string, args ...interface{}) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !write("retry: %d\n\n", streamRetry.Milliseconds()) {
+		return
+	}
+	if complete {
+		for _, event := range missed {
+			if !write("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data) {
+				return
+			}
+		}
+		// Move the client past events it was filtered out of, so it
+		// resumes from here
+		if !write("id: %d\n\n", sub.StartID) {
+			return
+		}
+	} else if !write("id: %d\nevent: %s\ndata: {}\n\n", sub.StartID, eventStreamReset) {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			// The subscription is closed when the client falls behind or
+			// the server shuts down; either way it can reconnect and resume
+			if !ok {
+				return
+			}
+			if !write("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data) {
+				return
+			}
+		case <-heartbeat.C:
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/stream"
+)
+
+// sseEvent is one event of a server-sent event stream. Comments are kept so
+// heartbeats can be seen.
+type sseEvent struct {
+	ID      string
+	Type    string
+	Data    string
+	Comment string
+}
+
+type sseClient struct {
+	reader *bufio.Reader
+}
+
+func newStreamTestServer(t *testing.T) (*apiConfig, *httptest.Server) {
+	t.Helper()
+	cfg, _ := newTestAPIConfig(t)
+	cfg.chirpStream = stream.NewBroker(1000, 3, 8)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/stream", cfg.handlerChirpsStream)
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		cfg.chirpStream.Close()
+		server.Close()
+	})
+	return cfg, server
+}
+
+func openStream(t *testing.T, server *httptest.Server, query, token, lastEventID string) *sseClient {
+	t.Helper()
+	req, err := http.NewRequest("GET", server.URL+"/api/chirps/stream"+query, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream: status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	return &sseClient{reader: bufio.NewReader(resp.Body)}
+}
+
+// next reads up to the blank line ending the next event.
+func (c *sseClient) next(t *testing.T) sseEvent {
+	t.Helper()
+	var event sseEvent
+	lines := make(chan string)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			line, err := c.reader.ReadString('\n')
+			if err != nil {
+				errs <- err
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			lines <- line
+			if line == "" {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case line := <-lines:
+			if line == "" {
+				return event
+			}
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "":
+				event.Comment = value
+			case "id":
+				event.ID = value
+			case "event":
+				event.Type = value
+			case "data":
+				event.Data = value
+			}
+		case err := <-errs:
+			t.Fatalf("reading stream: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+}
+
+// skipPreamble reads the retry field and the event moving the client to
+// the current ID, which open every stream.
+func (c *sseClient) skipPreamble(t *testing.T) sseEvent {
+	t.Helper()
+	c.next(t)
+	return c.next(t)
+}
+
+func TestChirpStreamAuthorFilter(t *testing.T) {
+	cfg, server := newStreamTestServer(t)
+	client := openStream(t, server, "?author_id=2", "", "")
+	if start := client.skipPreamble(t); start.ID != "1000" {
+		t.Fatalf("start = %+v, want id 1000", start)
+	}
+
+	cfg.publishChirpEvent("chirp.created", 1, map[string]int{"author_id": 1})
+	cfg.publishChirpEvent("chirp.created", 2, map[string]int{"author_id": 2})
+	event := client.next(t)
+	if event.ID != "1002" || event.Type != "chirp.created" || event.Data != `{"author_id":2}` {
+		t.Errorf("event = %+v, want chirp 1002 by author 2", event)
+	}
+}
+
+func TestChirpStreamTimeline(t *testing.T) {
+	cfg, server := newStreamTestServer(t)
+	var ids []int
+	for _, email := range []string{"walt@example.com", "jesse@example.com", "hank@example.com"} {
+		user, err := cfg.DB.CreateUser(email, "hash", strings.Split(email, "@")[0])
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		ids = append(ids, user.ID)
+	}
+	walt, jesse, hank := ids[0], ids[1], ids[2]
+	if _, err := cfg.DB.CreateFollow(walt, jesse); err != nil {
+		t.Fatalf("CreateFollow: %v", err)
+	}
+	token, err := cfg.createJWT(strconv.Itoa(walt), 0, time.Hour)
+	if err != nil {
+		t.Fatalf("createJWT: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/api/chirps/stream?timeline=true", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET stream: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("timeline without a token: status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	client := openStream(t, server, "?timeline=true", token, "")
+	client.skipPreamble(t)
+	for _, author := range []int{hank, jesse, walt} {
+		cfg.publishChirpEvent("chirp.created", author, map[string]int{"author_id": author})
+	}
+	for _, want := range []int{jesse, walt} {
+		event := client.next(t)
+		if event.Data != `{"author_id":`+strconv.Itoa(want)+`}` {
+			t.Errorf("event = %+v, want a chirp by %d", event, want)
+		}
+	}
+}
+
+func TestChirpStreamResume(t *testing.T) {
+	cfg, server := newStreamTestServer(t)
+	for i := 0; i < 5; i++ {
+		cfg.publishChirpEvent("chirp.created", 1, map[string]int{"n": i})
+	}
+	// 1001 to 1005 were published and the last three are buffered
+
+	client := openStream(t, server, "", "", "1003")
+	client.next(t)
+	for _, want := range []string{"1004", "1005"} {
+		if event := client.next(t); event.ID != want || event.Type != "chirp.created" {
+			t.Errorf("replayed event = %+v, want id %s", event, want)
+		}
+	}
+	if event := client.next(t); event.ID != "1005" || event.Type != "" {
+		t.Errorf("after the replay: %+v, want the client moved to 1005", event)
+	}
+
+	client = openStream(t, server, "", "", "1001")
+	client.next(t)
+	if event := client.next(t); event.ID != "1005" || event.Type != eventStreamReset {
+		t.Errorf("resuming from before the buffer: %+v, want %s at 1005", event, eventStreamReset)
+	}
+}
+
+func TestChirpStreamHeartbeat(t *testing.T) {
+	interval := streamHeartbeatInterval
+	streamHeartbeatInterval = 20 * time.Millisecond
+	t.Cleanup(func() { streamHeartbeatInterval = interval })
+
+	_, server := newStreamTestServer(t)
+	client := openStream(t, server, "", "", "")
+	client.skipPreamble(t)
+	if event := client.next(t); event.Comment != "heartbeat" {
+		t.Errorf("idle stream sent %+v, want a heartbeat", event)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/mailer"
+)
+
+const (
+	emailVerifyTTL   = 48 * time.Hour
+	passwordResetTTL = time.Hour
+	mailSendTimeout  = 30 * time.Second
+)
+
+// handlerUsersVerify marks the email of the user a verification token was
+// sent to as verified
+func (cfg *apiConfig) handlerUsersVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	params := parameters{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	token, err := cfg.DB.UseEmailToken(database.EmailTokenVerify, hashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(token.UserID)
+	if err != nil || user.Email != token.Email {
+		// The address changed after the email was sent
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+
+	err = cfg.DB.VerifyUserEmail(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerUsersVerifyResend sends the caller a fresh verification email
+func (cfg *apiConfig) handlerUsersVerifyResend(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(caller.ID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if user.EmailVerified {
+		respondWithError(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+
+	err = cfg.sendVerificationEmail(user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerPasswordForgot mails a password reset token. It responds the same
+// way whether or not the email belongs to an account.
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	// Unknown addresses are throttled too, so the response doesn't tell
+	// whether an account exists
+	if !cfg.checkResetThrottle(w, r, params.Email) {
+		return
+	}
+	cfg.recordResetRequest(r, params.Email)
+
+	user, err := cfg.DB.GetUserByEmail(params.Email)
+	if err == nil && !user.Suspended {
+		token, err := cfg.createEmailToken(user.ID, user.Email, database.EmailTokenReset, passwordResetTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create reset token")
+			return
+		}
+		cfg.sendMail(mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Chirpy password",
+			Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+				"Reset it at %s/app/reset-password?token=%s\n\n"+
+				"The link expires in one hour. If you didn't ask for this, you can ignore this email.\n",
+				cfg.publicURL, token),
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerPasswordReset sets a new password using a token from
+// handlerPasswordForgot and signs the user out everywhere
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if err := cfg.passwordPolicy.Validate(params.Password); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token, err := cfg.DB.UseEmailToken(database.EmailTokenReset, hashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+
+	hashedPassword, err := cfg.passwords.Hash(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		return
+	}
+
+	err = cfg.DB.UpdateUserPassword(token.UserID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password")
+		return
+	}
+
+	err = cfg.revokeAllUserTokens(token.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke existing tokens")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendVerificationEmail mails the user a token that verifies their address
+func (cfg *apiConfig) sendVerificationEmail(user database.User) error {
+	token, err := cfg.createEmailToken(user.ID, user.Email, database.EmailTokenVerify, emailVerifyTTL)
+	if err != nil {
+		return err
+	}
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\n"+
+			"Verify your email at %s/app/verify-email?token=%s\n\n"+
+			"Until then you can post up to %d chirps a day.\n",
+			cfg.publicURL, token, unverifiedChirpsPerDay),
+	})
+	return nil
+}
+
+// createEmailToken stores the hash of a new single-use token for the user and
+// the email it will be sent to, and returns the token
+func (cfg *apiConfig) createEmailToken(userID int, email, purpose string, ttl time.Duration) (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", fmt.Errorf("failed to generate email token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	_, err := cfg.DB.CreateEmailToken(userID, purpose, email, hashToken(token), time.Now().UTC().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendMail sends in the background so slow mail servers don't hold up
+// requests, or reveal through timing whether an account exists. Shutting
+// down waits for it.
+func (cfg *apiConfig) sendMail(msg mailer.Message) {
+	cfg.background.Add(1)
+	go func() {
+		defer cfg.background.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := cfg.mailer.Send(ctx, msg); err != nil {
+			cfg.logger.Error("Couldn't send email", "subject", msg.Subject, "err", err)
+		}
+	}()
+}
+
+// validateEmail accepts bare addresses like "walt@example.com"
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("Invalid email address")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func newEmailTestMux(cfg *apiConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/users/verify", cfg.handlerUsersVerify)
+	mux.HandleFunc("POST /api/password/forgot", cfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", cfg.handlerPasswordReset)
+	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	return mux
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func TestSignupVerifiesEmail(t *testing.T) {
+	cfg, mail := newTestAPIConfig(t)
+	mux := newEmailTestMux(cfg)
+
+	rec := doJSON(t, mux, "POST", "/api/users", "", credentials{"walt@example.com", "correct horse"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("signup: status %d: %s", rec.Code, rec.Body)
+	}
+	token := mailedToken(t, cfg, mail, "walt@example.com", "verify-email")
+
+	rec = doJSON(t, mux, "POST", "/api/users/verify", "", map[string]string{"token": token})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("verify: status %d: %s", rec.Code, rec.Body)
+	}
+	user, err := cfg.DB.GetUserByEmail("walt@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if !user.EmailVerified {
+		t.Error("email not verified")
+	}
+
+	rec = doJSON(t, mux, "POST", "/api/users/verify", "", map[string]string{"token": token})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("reusing the token: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	cfg, mail := newTestAPIConfig(t)
+	mux := newEmailTestMux(cfg)
+
+	rec := doJSON(t, mux, "POST", "/api/users", "", credentials{"walt@example.com", "correct horse"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("signup: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = doJSON(t, mux, "POST", "/api/password/forgot", "", map[string]string{"email": "walt@example.com"})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("forgot: status %d: %s", rec.Code, rec.Body)
+	}
+	token := mailedToken(t, cfg, mail, "walt@example.com", "reset-password")
+
+	rec = doJSON(t, mux, "POST", "/api/password/reset", "", map[string]string{"token": token, "password": "battery staple"})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("reset: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = doJSON(t, mux, "POST", "/api/login", "", credentials{"walt@example.com", "battery staple"})
+	if rec.Code != http.StatusOK {
+		t.Errorf("login with the new password: status %d: %s", rec.Code, rec.Body)
+	}
+	rec = doJSON(t, mux, "POST", "/api/password/reset", "", map[string]string{"token": token, "password": "another password"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("reusing the token: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPasswordForgotUnknownEmail(t *testing.T) {
+	cfg, mail := newTestAPIConfig(t)
+	mux := newEmailTestMux(cfg)
+
+	rec := doJSON(t, mux, "POST", "/api/password/forgot", "", map[string]string{"email": "nobody@example.com"})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("forgot: status %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	cfg.background.Wait()
+	if n := len(mail.Messages()); n != 0 {
+		t.Errorf("%d emails sent for an unknown address", n)
+	}
+}
+
+func TestPasswordForgotThrottled(t *testing.T) {
+	cfg, mail := newTestAPIConfig(t)
+	mux := newEmailTestMux(cfg)
+
+	rec := doJSON(t, mux, "POST", "/api/users", "", credentials{"walt@example.com", "correct horse"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("signup: status %d: %s", rec.Code, rec.Body)
+	}
+
+	for i := 0; i < 3; i++ {
+		rec = doJSON(t, mux, "POST", "/api/password/forgot", "", map[string]string{"email": "walt@example.com"})
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("request %d: status %d: %s", i+1, rec.Code, rec.Body)
+		}
+	}
+	token := mailedToken(t, cfg, mail, "walt@example.com", "reset-password")
+
+	rec = doJSON(t, mux, "POST", "/api/password/forgot", "", map[string]string{"email": "walt@example.com"})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("fourth request: status %d, Retry-After %q; want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// Unknown addresses are throttled alike
+	for i := 0; i < 3; i++ {
+		doJSON(t, mux, "POST", "/api/password/forgot", "", map[string]string{"email": "nobody@example.com"})
+	}
+	rec = doJSON(t, mux, "POST", "/api/password/forgot", "", map[string]string{"email": "nobody@example.com"})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("fourth request for an unknown address: status %d, want 429", rec.Code)
+	}
+
+	// The throttled request didn't replace the last link
+	cfg.background.Wait()
+	if got := mailedToken(t, cfg, mail, "walt@example.com", "reset-password"); got != token {
+		t.Error("a throttled request mailed a new link")
+	}
+	rec = doJSON(t, mux, "POST", "/api/password/reset", "", map[string]string{"token": token, "password": "battery staple"})
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("reset with the last link: status %d: %s", rec.Code, rec.Body)
+	}
+}
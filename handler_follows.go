@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerFollowsCreate(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	followee, err := cfg.lookupUser(r.PathValue("idOrHandle"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if followee.ID == caller.ID {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself")
+		return
+	}
+
+	_, err = cfg.DB.CreateFollow(caller.ID, followee.ID)
+	if errors.Is(err, database.ErrAlreadyFollowing) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user")
+		return
+	}
+	cfg.emitEvent(eventUserFollowed, followee.ID, map[string]int{"follower_id": caller.ID, "followee_id": followee.ID})
+	cfg.notify(database.Notification{
+		UserID:  followee.ID,
+		Type:    database.NotificationFollow,
+		ActorID: caller.ID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerFollowsDelete(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	followee, err := cfg.lookupUser(r.PathValue("idOrHandle"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	err = cfg.DB.DeleteFollow(caller.ID, followee.ID)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
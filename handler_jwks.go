@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/keyring"
+)
+
+// handlerJWKS publishes the public keys tokens are signed with, so other
+// services can verify Chirpy tokens without a shared secret.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, struct {
+		Keys []keyring.JWK `json:"keys"`
+	}{
+		Keys: cfg.jwtKeys.JWKS(),
+	})
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/blobstore"
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/imaging"
+)
+
+const (
+	maxAttachmentBytes = 5 << 20
+	maxAvatarBytes     = 2 << 20
+	// maxImagePixels bounds the memory a decoded upload can take, whatever
+	// its file size
+	maxImagePixels = 16_000_000
+
+	attachmentSize          = 2048
+	attachmentThumbnailSize = 400
+	avatarSize              = 400
+	avatarThumbnailSize     = 96
+
+	maxMediaPerChirp = 4
+
+	// mediaCleanupInterval is how often uploads that were never attached
+	// are looked for
+	mediaCleanupInterval = time.Hour
+)
+
+var (
+	errUploadTooLarge = errors.New("upload too large")
+	errStoreImage     = errors.New("failed to store image")
+)
+
+// Media is an image attached, or about to be attached, to a chirp
+type Media struct {
+	ID           int    `json:"id"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	ContentType  string `json:"content_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// handlerMediaUpload stores an image sent as the "file" field of a multipart
+// form. It can then be attached to a chirp by passing its ID in media_ids.
+func (cfg *apiConfig) handlerMediaUpload(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	data, err := readUpload(w, r, maxAttachmentBytes)
+	if err != nil {
+		respondWithUploadError(w, err, maxAttachmentBytes)
+		return
+	}
+
+	img, err := cfg.storeImage(data, func(img *image.NRGBA) (*image.NRGBA, *image.NRGBA) {
+		return imaging.Fit(img, attachmentSize), imaging.Fit(img, attachmentThumbnailSize)
+	})
+	if err != nil {
+		respondWithUploadError(w, err, maxAttachmentBytes)
+		return
+	}
+
+	media, err := cfg.DB.CreateMedia(user.ID, img)
+	if err != nil {
+		cfg.deleteImage(img)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save media")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, newMedia(media))
+}
+
+// handlerMediaDelete deletes an upload that was never attached to a chirp.
+// Attached media are deleted with their chirp.
+func (cfg *apiConfig) handlerMediaDelete(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(r.PathValue("mediaID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid media ID")
+		return
+	}
+
+	media, err := cfg.DB.DeleteMedia(mediaID, user.ID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Media not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete media")
+		return
+	}
+	cfg.deleteImage(media.Image)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerMediaServe serves stored images. Keys are random and never reused,
+// so clients and proxies may cache them for good.
+func (cfg *apiConfig) handlerMediaServe(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	blob, err := cfg.blobs.Open(key)
+	if errors.Is(err, blobstore.ErrNotExist) || errors.Is(err, blobstore.ErrInvalidKey) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open media")
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(key)))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+key+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, key, blob.ModTime(), blob)
+}
+
+// readUpload reads the "file" field of a multipart upload, refusing files
+// larger than maxBytes
+func readUpload(w http.ResponseWriter, r *http.Request, maxBytes int64) ([]byte, error) {
+	// Leave some room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, errUploadTooLarge
+			}
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("missing file field")
+			}
+			return nil, err
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, maxBytes+1))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) || int64(len(data)) > maxBytes {
+			return nil, errUploadTooLarge
+		}
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+}
+
+func respondWithUploadError(w http.ResponseWriter, err error, maxBytes int64) {
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("File must be at most %d MiB", maxBytes>>20))
+	case errors.Is(err, imaging.ErrUnsupportedFormat):
+		respondWithError(w, http.StatusUnsupportedMediaType, "File must be a PNG, JPEG or GIF image")
+	case errors.Is(err, imaging.ErrTooLarge):
+		respondWithError(w, http.StatusBadRequest, "Image dimensions are too large")
+	case errors.Is(err, errStoreImage):
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store image")
+	default:
+		respondWithError(w, http.StatusBadRequest, "Expected a multipart form with a file field")
+	}
+}
+
+// storeImage decodes an upload and stores the two versions of it that
+// variants makes. Re-encoding strips metadata such as EXIF location and
+// anything smuggled in after the image data.
+func (cfg *apiConfig) storeImage(data []byte, variants func(*image.NRGBA) (full, thumbnail *image.NRGBA)) (database.Image, error) {
+	decoded, format, err := imaging.Decode(data, maxImagePixels)
+	if err != nil {
+		return database.Image{}, err
+	}
+	full, thumbnail := variants(decoded)
+
+	name, err := randomBlobName()
+	if err != nil {
+		return database.Image{}, fmt.Errorf("%w: %v", errStoreImage, err)
+	}
+
+	fullKey, contentType, err := cfg.putImage(name, full, format)
+	if err != nil {
+		return database.Image{}, fmt.Errorf("%w: %v", errStoreImage, err)
+	}
+	thumbnailKey, _, err := cfg.putImage(name+"_thumb", thumbnail, format)
+	if err != nil {
+		cfg.deleteBlob(fullKey)
+		return database.Image{}, fmt.Errorf("%w: %v", errStoreImage, err)
+	}
+
+	return database.Image{
+		Key:          fullKey,
+		ThumbnailKey: thumbnailKey,
+		ContentType:  contentType,
+		Width:        full.Bounds().Dx(),
+		Height:       full.Bounds().Dy(),
+	}, nil
+}
+
+func (cfg *apiConfig) putImage(name string, img image.Image, format imaging.Format) (key, contentType string, err error) {
+	buf := &bytes.Buffer{}
+	format, err = imaging.Encode(buf, img, format)
+	if err != nil {
+		return "", "", err
+	}
+	key = name + "." + format.Extension()
+	if err := cfg.blobs.Put(key, buf); err != nil {
+		return "", "", err
+	}
+	return key, format.ContentType(), nil
+}
+
+// cleanUpMedia deletes uploads that weren't attached to a chirp within ttl,
+// every mediaCleanupInterval until ctx is cancelled.
+func (cfg *apiConfig) cleanUpMedia(ctx context.Context, ttl time.Duration) {
+	ticker := time.NewTicker(mediaCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		media, err := cfg.DB.DeleteUnattachedMedia(time.Now().UTC().Add(-ttl))
+		if err != nil {
+			cfg.logger.Error("Couldn't delete unattached media", "err", err)
+		}
+		for _, m := range media {
+			cfg.deleteImage(m.Image)
+		}
+		if len(media) > 0 {
+			cfg.logger.Info("Deleted unattached media", "count", len(media))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deleteImage deletes both blobs of an image. Failures only leave orphaned
+// files behind, so they are logged rather than returned.
+func (cfg *apiConfig) deleteImage(img database.Image) {
+	cfg.deleteBlob(img.Key)
+	cfg.deleteBlob(img.ThumbnailKey)
+}
+
+func (cfg *apiConfig) deleteBlob(key string) {
+	if err := cfg.blobs.Delete(key); err != nil {
+		cfg.logger.Error("Couldn't delete blob", "key", key, "err", err)
+	}
+}
+
+func randomBlobName() (string, error) {
+	nameBytes := make([]byte, 16)
+	if _, err := rand.Read(nameBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nameBytes), nil
+}
+
+// mediaURL is the path a stored blob is served from
+func mediaURL(key string) string {
+	return "/media/" + key
+}
+
+// avatarURLs returns the URLs of the user's avatar and its thumbnail, or
+// empty strings if they have none
+func avatarURLs(user database.User) (url, thumbnailURL string) {
+	if user.Avatar == nil {
+		return "", ""
+	}
+	return mediaURL(user.Avatar.Key), mediaURL(user.Avatar.ThumbnailKey)
+}
+
+func newMedia(media database.Media) Media {
+	return Media{
+		ID:           media.ID,
+		URL:          mediaURL(media.Image.Key),
+		ThumbnailURL: mediaURL(media.Image.ThumbnailKey),
+		ContentType:  media.Image.ContentType,
+		Width:        media.Image.Width,
+		Height:       media.Image.Height,
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/blobstore"
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+func TestCleanUpMedia(t *testing.T) {
+	cfg, _ := newTestAPIConfig(t)
+	blobs, err := blobstore.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	cfg.blobs = blobs
+
+	var uploads []database.Media
+	for _, name := range []string{"attached", "orphaned"} {
+		img := database.Image{Key: name + ".png", ThumbnailKey: name + "_thumb.png", ContentType: "image/png"}
+		for _, key := range []string{img.Key, img.ThumbnailKey} {
+			if err := blobs.Put(key, strings.NewReader("png")); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+		}
+		media, err := cfg.DB.CreateMedia(1, img)
+		if err != nil {
+			t.Fatalf("CreateMedia: %v", err)
+		}
+		uploads = append(uploads, media)
+	}
+	chirp, err := cfg.DB.CreateChirp("with a picture", 1, []int{uploads[0].ID})
+	if err != nil {
+		t.Fatalf("CreateChirp: %v", err)
+	}
+
+	// A cancelled context makes one pass; a negative TTL makes every
+	// upload old enough
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg.cleanUpMedia(ctx, -time.Hour)
+
+	media, err := cfg.DB.GetMediaByChirpIDs([]int{chirp.ID})
+	if err != nil {
+		t.Fatalf("GetMediaByChirpIDs: %v", err)
+	}
+	if len(media[chirp.ID]) != 1 {
+		t.Errorf("attached media = %+v, want the attached upload kept", media[chirp.ID])
+	}
+	if _, err := cfg.DB.DeleteMedia(uploads[1].ID, 1); !errors.Is(err, database.ErrNotExist) {
+		t.Errorf("orphaned upload still exists: err = %v", err)
+	}
+
+	for key, want := range map[string]bool{
+		"attached.png": true, "attached_thumb.png": true,
+		"orphaned.png": false, "orphaned_thumb.png": false,
+	} {
+		blob, err := blobs.Open(key)
+		if err == nil {
+			blob.Close()
+		}
+		if exists := err == nil; exists != want {
+			t.Errorf("blob %s exists = %v, want %v", key, exists, want)
+		}
+	}
+}
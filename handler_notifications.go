@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+const (
+	defaultNotificationPageSize = 50
+	maxNotificationPageSize     = 100
+)
+
+// handlerNotificationsList lists the caller's notifications, newest first.
+// unread=true lists only unread ones, and they can be paged through with
+// limit and before, the smallest ID of the previous page.
+func (cfg *apiConfig) handlerNotificationsList(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+
+	limit := defaultNotificationPageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxNotificationPageSize {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxNotificationPageSize))
+			return
+		}
+		limit = n
+	}
+
+	before := 0
+	if v := query.Get("before"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid before")
+			return
+		}
+		before = n
+	}
+
+	unreadOnly := false
+	switch query.Get("unread") {
+	case "":
+	case "true":
+		unreadOnly = true
+	case "false":
+	default:
+		respondWithError(w, http.StatusBadRequest, "unread must be true or false")
+		return
+	}
+
+	dbNotifications, err := cfg.DB.GetNotifications(user.ID, unreadOnly, before, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve notifications")
+		return
+	}
+
+	notifications, err := cfg.newNotifications(dbNotifications)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve notifications")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, notifications)
+}
+
+func (cfg *apiConfig) handlerNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	notificationID, err := strconv.Atoi(r.PathValue("notificationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid notification ID")
+		return
+	}
+
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	_, err = cfg.DB.GetNotification(user.ID, notificationID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve notification")
+		return
+	}
+
+	_, err = cfg.markNotificationsRead(user.ID, []int{notificationID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark notification read")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerNotificationsReadAll(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	_, err = cfg.markAllNotificationsRead(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark notifications read")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerNotificationPreferencesGet responds with whether each type of
+// notification is turned on.
+func (cfg *apiConfig) handlerNotificationPreferencesGet(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(caller.ID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, notificationPreferences(user))
+}
+
+// handlerNotificationPreferencesUpdate turns types of notifications on or
+// off. Types left out of the request keep their setting.
+func (cfg *apiConfig) handlerNotificationPreferencesUpdate(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	params := map[string]bool{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+	for notificationType, enabled := range params {
+		if !slices.Contains(notificationTypes, notificationType) {
+			respondWithError(w, http.StatusBadRequest, "Unknown notification type: "+notificationType)
+			return
+		}
+		if notificationType == database.NotificationModeration && !enabled {
+			respondWithError(w, http.StatusBadRequest, "Moderation notifications can't be turned off")
+			return
+		}
+	}
+
+	user, err := cfg.DB.GetUserByID(caller.ID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	muted := []string{}
+	for _, notificationType := range notificationTypes {
+		enabled, ok := params[notificationType]
+		if !ok {
+			enabled = !slices.Contains(user.MutedNotifications, notificationType)
+		}
+		if !enabled {
+			muted = append(muted, notificationType)
+		}
+	}
+
+	user, err = cfg.DB.SetMutedNotifications(user.ID, muted)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update notification preferences")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, notificationPreferences(user))
+}
+
+func notificationPreferences(user database.User) map[string]bool {
+	preferences := map[string]bool{}
+	for _, notificationType := range notificationTypes {
+		preferences[notificationType] = !slices.Contains(user.MutedNotifications, notificationType)
+	}
+	return preferences
+}
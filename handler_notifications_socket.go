@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/websocket"
+)
+
+const (
+	socketPingInterval = 30 * time.Second
+	// socketReadTimeout closes sockets that stop answering pings
+	socketReadTimeout  = 2*socketPingInterval + 10*time.Second
+	socketWriteTimeout = 10 * time.Second
+	// socketBacklogSize caps how many missed notifications are sent on
+	// connecting; clients further behind page through the REST API
+	socketBacklogSize = 100
+)
+
+// handlerNotificationsSocket pushes the caller's notifications over a
+// WebSocket. Clients that pass after, the ID of the last notification they
+// saw, are first sent those they missed. Clients can mark notifications
+// read by sending {"type":"mark_read","ids":[...]}, or all of them with
+// {"type":"mark_all_read"}.
+func (cfg *apiConfig) handlerNotificationsSocket(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticateSocket(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	after := -1
+	if s := r.URL.Query().Get("after"); s != "" {
+		after, err = strconv.Atoi(s)
+		if err != nil || after < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid after")
+			return
+		}
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	// Subscribe before loading the backlog so nothing created in between is
+	// missed; the writer skips what it sent already
+	socket := cfg.notificationHub.subscribe(user.ID)
+	defer cfg.notificationHub.unsubscribe(socket)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cfg.readNotificationSocket(conn, user.ID)
+	}()
+
+	cfg.writeNotificationSocket(conn, user.ID, socket, after, done)
+	conn.Close()
+	<-done
+}
+
+// authenticateSocket authenticates a socket by its Authorization header or,
+// since browsers can't set headers on WebSockets, an access_token query
+// parameter. Either way only JWTs are accepted.
+func (cfg *apiConfig) authenticateSocket(r *http.Request) (authUser, error) {
+	if r.Header.Get("Authorization") != "" {
+		return cfg.authenticate(r, "")
+	}
+
+	tokenString := r.URL.Query().Get("access_token")
+	if tokenString == "" {
+		return authUser{}, errUnauthenticated
+	}
+	claims, err := cfg.validateJWT(tokenString)
+	if err != nil {
+		return authUser{}, errUnauthenticated
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return authUser{}, errUnauthenticated
+	}
+	setRequestUser(r, userID)
+
+	return authUser{ID: userID, SessionID: claims.SessionID}, nil
+}
+
+// writeNotificationSocket sends the unread count and backlog, then relays
+// the user's messages and pings the client until the reader is done or the
+// socket falls behind.
+func (cfg *apiConfig) writeNotificationSocket(conn *websocket.Conn, userID int, socket *hubSocket, after int, done <-chan struct{}) {
+	write := func(data []byte) bool {
+		conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+		return conn.WriteMessage(websocket.TextMessage, data) == nil
+	}
+	writeMessage := func(msg socketMessage) bool {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			cfg.logger.Error("Couldn't marshal socket message", "type", msg.Type, "err", err)
+			return false
+		}
+		return write(data)
+	}
+
+	unread, err := cfg.DB.CountUnreadNotifications(userID)
+	if err != nil {
+		conn.WriteClose(websocket.CloseGoingAway, "couldn't load notifications")
+		return
+	}
+	if !writeMessage(socketMessage{Type: "unread_count", UnreadCount: &unread}) {
+		return
+	}
+
+	lastSent := after
+	if after >= 0 {
+		dbNotifications, err := cfg.DB.GetNotificationsAfter(userID, after, socketBacklogSize)
+		if err == nil {
+			var notifications []Notification
+			notifications, err = cfg.newNotifications(dbNotifications)
+			for i := range notifications {
+				if !writeMessage(socketMessage{Type: "notification", Notification: &notifications[i]}) {
+					return
+				}
+				lastSent = notifications[i].ID
+			}
+		}
+		if err != nil {
+			conn.WriteClose(websocket.CloseGoingAway, "couldn't load notifications")
+			return
+		}
+	}
+
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case msg, ok := <-socket.c:
+			if !ok {
+				code, reason := websocket.CloseGoingAway, "falling behind, reconnect to catch up"
+				switch {
+				case socket.signedOut:
+					code, reason = websocket.ClosePolicyViolation, "signed out"
+				case cfg.notificationHub.isClosed():
+					reason = "server shutting down"
+				}
+				conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+				conn.WriteClose(code, reason)
+				return
+			}
+			if msg.NotificationID != 0 && msg.NotificationID <= lastSent {
+				continue
+			}
+			if !write(msg.Data) {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+			if conn.Ping() != nil {
+				return
+			}
+		}
+	}
+}
+
+// readNotificationSocket handles messages from the client until it closes
+// the socket or stops responding.
+func (cfg *apiConfig) readNotificationSocket(conn *websocket.Conn, userID int) {
+	conn.OnPong = func() {
+		conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				conn.Close()
+			}
+			return
+		}
+
+		var msg struct {
+			Type string `json:"type"`
+			IDs  []int  `json:"ids"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			cfg.writeSocketError(conn, "Invalid JSON")
+			continue
+		}
+
+		switch msg.Type {
+		// Sockets learn what was marked read through the hub, this one too
+		case "mark_read":
+			if len(msg.IDs) == 0 {
+				cfg.writeSocketError(conn, "ids is required")
+				continue
+			}
+			if _, err := cfg.markNotificationsRead(userID, msg.IDs); err != nil {
+				cfg.writeSocketError(conn, "Couldn't mark notifications read")
+			}
+		case "mark_all_read":
+			if _, err := cfg.markAllNotificationsRead(userID); err != nil {
+				cfg.writeSocketError(conn, "Couldn't mark notifications read")
+			}
+		default:
+			cfg.writeSocketError(conn, "Unknown message type: "+msg.Type)
+		}
+	}
+}
+
+func (cfg *apiConfig) writeSocketError(conn *websocket.Conn, message string) {
+	data, err := json.Marshal(socketMessage{Type: "error", Error: message})
+	if err != nil {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	conn.WriteMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/websocket"
+)
+
+// socketClient reads the frames a notification socket sends.
+type socketClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialNotifications(t *testing.T, server *httptest.Server, token string) *socketClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET /api/notifications/ws?access_token="+token+" HTTP/1.1\r\n"+
+		"Host: chirpy.test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	return &socketClient{conn: conn, br: br}
+}
+
+// readFrame reads one short unfragmented frame.
+func (c *socketClient) readFrame(t *testing.T) (opcode int, payload []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatalf("reading payload: %v", err)
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+func (c *socketClient) readMessage(t *testing.T) socketMessage {
+	t.Helper()
+	opcode, payload := c.readFrame(t)
+	if opcode != websocket.TextMessage {
+		t.Fatalf("opcode = %d (%q), want a text message", opcode, payload)
+	}
+	var msg socketMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		t.Fatalf("decoding %q: %v", payload, err)
+	}
+	return msg
+}
+
+func TestNotificationSocketClosesOnSignOut(t *testing.T) {
+	cfg, _ := newTestAPIConfig(t)
+	user, err := cfg.DB.CreateUser("walt@example.com", "hash", "walt")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	token, err := cfg.createJWT(strconv.Itoa(user.ID), 0, time.Hour)
+	if err != nil {
+		t.Fatalf("createJWT: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/notifications/ws", cfg.handlerNotificationsSocket)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := dialNotifications(t, server, token)
+	if msg := client.readMessage(t); msg.Type != "unread_count" || msg.UnreadCount == nil || *msg.UnreadCount != 0 {
+		t.Fatalf("first message = %+v, want an unread count of 0", msg)
+	}
+
+	if err := cfg.revokeAllUserTokens(user.ID); err != nil {
+		t.Fatalf("revokeAllUserTokens: %v", err)
+	}
+
+	opcode, payload := client.readFrame(t)
+	if opcode != 8 || len(payload) < 2 {
+		t.Fatalf("frame = %d/%q, want a close frame", opcode, payload)
+	}
+	if code := int(binary.BigEndian.Uint16(payload)); code != websocket.ClosePolicyViolation || string(payload[2:]) != "signed out" {
+		t.Errorf("close = %d %q, want %d signed out", code, payload[2:], websocket.ClosePolicyViolation)
+	}
+}
@@ -1,63 +1,277 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/webhook"
+)
+
+// polkaSignatureHeader carries the timestamp and HMAC signatures of a Polka
+// webhook
+const polkaSignatureHeader = "Polka-Signature"
+
+// maxWebhookBytes caps the body read before the signature is checked
+const maxWebhookBytes = 64 << 10
+
+// chirpyRedPeriod is how long an upgrade or renewal lasts when Polka doesn't
+// say when it expires
+const chirpyRedPeriod = 30 * 24 * time.Hour
+
+// polkaSource identifies Polka's events in the webhook event log
+const polkaSource = "polka"
+
+var (
+	errWebhookUserNotFound = errors.New("user not found")
+	errRecordWebhookEvent  = errors.New("failed to record webhook event outcome")
 )
 
 type PolkaWebhook struct {
+	ID    string `json:"id"`
 	Event string `json:"event"`
 	Data  struct {
-		UserID int `json:"user_id"`
+		UserID    int        `json:"user_id"`
+		ExpiresAt *time.Time `json:"expires_at"`
 	} `json:"data"`
 }
 
+// handlerPolkaWebhooks records each event before processing it. Events are
+// claimed before they're applied and processed at most once successfully, so
+// redeliveries are harmless.
 func (cfg *apiConfig) handlerPolkaWebhooks(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithBodyTooLarge(w, maxBytesErr.Limit)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read body")
+		return
+	}
 
-	// {
-	// "event": "user.upgraded",
-	// "data": {
-	// "user_id": 3
-	// }
-	// }
-
-	auth := r.Header.Get("Authorization")
-	// tokenString := strings.TrimPrefix(auth, "Bearer ")
-	apiKey := strings.TrimPrefix(auth, "ApiKey ")
-
-	if apiKey != cfg.polkaAPIKey {
-		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+	verified, ok := cfg.authenticatePolka(w, r, body)
+	if !ok {
 		return
 	}
 
 	var webhook PolkaWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
 
-	decoder := json.NewDecoder(r.Body)
+	// Events without an ID can't be told apart from a legitimate repeat, such
+	// as upgrading again after a downgrade, so each is logged on its own and
+	// always processed
+	eventID := webhook.ID
+	if eventID == "" {
+		eventID, err = unkeyedEventID()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record webhook event")
+			return
+		}
+	}
 
-	if err := decoder.Decode(&webhook); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+	event, created, err := cfg.DB.RecordWebhookEvent(polkaSource, eventID, webhook.Event, body)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record webhook event")
+		return
+	}
+	// An event processed already is acknowledged even when this delivery is
+	// an exact replay, so a sender retrying a lost response stops retrying
+	if !created && event.Done() {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	_, err := cfg.DB.GetUserByID(webhook.Data.UserID)
+	if !cfg.usePolkaDelivery(w, r, verified) {
+		return
+	}
 
+	event, err = cfg.DB.ClaimWebhookEvent(event.ID, false)
+	if errors.Is(err, database.ErrWebhookEventDone) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if errors.Is(err, database.ErrWebhookEventBusy) {
+		respondWithError(w, http.StatusConflict, "Webhook event is being processed")
+		return
+	}
 	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't claim webhook event")
+		return
+	}
+
+	_, err = cfg.processPolkaEvent(event)
+	if errors.Is(err, errWebhookUserNotFound) {
 		respondWithError(w, http.StatusNotFound, "User not found")
 		return
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process webhook event")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// processPolkaEvent applies a claimed event and records the outcome. The
+// returned error is the processing error, or errRecordWebhookEvent if the
+// outcome couldn't be recorded.
+func (cfg *apiConfig) processPolkaEvent(event database.WebhookEvent) (database.WebhookEvent, error) {
+	status, processErr := cfg.applyPolkaEvent(event.Payload)
+	errMsg := ""
+	if processErr != nil {
+		status = database.WebhookFailed
+		errMsg = processErr.Error()
+		cfg.logger.Error("Couldn't process Polka event", "event_id", event.EventID, "err", processErr)
+	}
+
+	cfg.metrics.polkaWebhooks.With(status).Inc()
+
+	event, err := cfg.DB.FinishWebhookEvent(event.ID, status, errMsg)
+	if err != nil {
+		return event, fmt.Errorf("%w: %v", errRecordWebhookEvent, err)
+	}
+	return event, processErr
+}
 
-	if webhook.Event == "user.upgraded" {
-		err := cfg.DB.UpgradeUser(webhook.Data.UserID)
+func (cfg *apiConfig) applyPolkaEvent(payload []byte) (status string, err error) {
+	var webhook PolkaWebhook
+	if err := json.Unmarshal(payload, &webhook); err != nil {
+		return "", err
+	}
+
+	switch webhook.Event {
+	case "user.upgraded", "subscription.renewed":
+		user, err := cfg.DB.GetUserByID(webhook.Data.UserID)
+		if errors.Is(err, database.ErrNotExist) {
+			return "", errWebhookUserNotFound
+		}
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Couldn't upgrade user")
+			return "", err
 		}
-		w.WriteHeader(http.StatusNoContent)
-		// send a empty object
-		w.Write([]byte("{}"))
-		return
+
+		expiresAt := time.Now().UTC().Add(chirpyRedPeriod)
+		if webhook.Data.ExpiresAt != nil {
+			expiresAt = webhook.Data.ExpiresAt.UTC()
+		} else if webhook.Event == "subscription.renewed" && user.IsChirpyRed() && user.Subscription.ExpiresAt != nil {
+			// Renewals extend the current period rather than restart it
+			expiresAt = user.Subscription.ExpiresAt.Add(chirpyRedPeriod)
+		}
+
+		upgraded, err := cfg.DB.UpgradeUser(user.ID, expiresAt)
+		if err != nil {
+			return "", err
+		}
+		cfg.emitEvent(eventUserUpgraded, user.ID, map[string]interface{}{
+			"user_id":          user.ID,
+			"chirpy_red_until": upgraded.Subscription.ExpiresAt,
+		})
+		detail := "upgraded"
+		if webhook.Event == "subscription.renewed" {
+			detail = "renewed"
+		}
+		cfg.notify(database.Notification{
+			UserID: user.ID,
+			Type:   database.NotificationChirpyRed,
+			Detail: detail,
+		})
+	case "user.downgraded", "subscription.expired":
+		reason := database.SubscriptionDowngraded
+		if webhook.Event == "subscription.expired" {
+			reason = database.SubscriptionExpired
+		}
+		_, err := cfg.DB.EndSubscription(webhook.Data.UserID, reason)
+		if errors.Is(err, database.ErrNotExist) {
+			return "", errWebhookUserNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+		cfg.emitEvent(eventUserDowngraded, webhook.Data.UserID, map[string]interface{}{
+			"user_id": webhook.Data.UserID,
+			"reason":  reason,
+		})
+		cfg.notify(database.Notification{
+			UserID: webhook.Data.UserID,
+			Type:   database.NotificationChirpyRed,
+			Detail: reason,
+		})
+	default:
+		return database.WebhookIgnored, nil
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	return database.WebhookProcessed, nil
+}
+
+// unkeyedEventID makes a unique event log key for an event sent without an
+// ID, from the time it was received and a random suffix
+func unkeyedEventID() (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return "unkeyed:" + time.Now().UTC().Format(time.RFC3339Nano) + "-" + hex.EncodeToString(suffix), nil
+}
+
+// authenticatePolka checks the webhook's signature and rejects stale
+// deliveries. Without signing secrets it falls back to the legacy static API
+// key, and the returned delivery is nil. It responds itself when the check
+// fails.
+func (cfg *apiConfig) authenticatePolka(w http.ResponseWriter, r *http.Request, body []byte) (*webhook.Verified, bool) {
+	if cfg.polkaWebhooks == nil {
+		apiKey, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey ")
+		if !ok || cfg.polkaAPIKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.polkaAPIKey)) != 1 {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+			return nil, false
+		}
+		return nil, true
+	}
+
+	verified, err := cfg.polkaWebhooks.Verify(r.Header.Get(polkaSignatureHeader), body, time.Now().UTC())
+	if errors.Is(err, webhook.ErrStale) {
+		respondWithError(w, http.StatusUnauthorized, "Webhook timestamp is too old or too far in the future")
+		return nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid webhook signature")
+		return nil, false
+	}
+
+	return &verified, true
+}
+
+// usePolkaDelivery rejects an exact replay of a signed delivery already
+// received. It responds itself when it does.
+func (cfg *apiConfig) usePolkaDelivery(w http.ResponseWriter, r *http.Request, verified *webhook.Verified) bool {
+	if verified == nil {
+		return true
+	}
+
+	// A delivery stays acceptable until its timestamp leaves the tolerance
+	// window, so it only needs remembering until then
+	expiresAt := verified.Timestamp.Add(cfg.polkaWebhooks.Tolerance)
+	err := cfg.DB.UseWebhookDelivery(verified.ID, expiresAt)
+	if errors.Is(err, database.ErrWebhookReplayed) {
+		cfg.requestLogger(r).Warn("Rejected replayed Polka webhook", "sent_at", verified.Timestamp)
+		respondWithError(w, http.StatusConflict, "Webhook was already delivered")
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record webhook")
+		return false
+	}
 
+	return true
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/webhook"
+)
+
+var testPolkaSecret = []byte("polka-secret")
+
+func newPolkaTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	cfg, _ := newTestAPIConfig(t)
+	cfg.polkaWebhooks = &webhook.Verifier{
+		Secrets:   [][]byte{testPolkaSecret},
+		Tolerance: webhook.DefaultTolerance,
+	}
+	return cfg
+}
+
+func postPolka(t *testing.T, cfg *apiConfig, signature string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/polka/webhooks", bytes.NewReader(body))
+	req.Header.Set(polkaSignatureHeader, signature)
+	rec := httptest.NewRecorder()
+	cfg.handlerPolkaWebhooks(rec, req)
+	return rec
+}
+
+func TestPolkaWebhookRedeliveryOfProcessedEvent(t *testing.T) {
+	cfg := newPolkaTestConfig(t)
+	user, err := cfg.DB.CreateUser("walt@example.com", "hash", "walt")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":` + strconv.Itoa(user.ID) + `}}`)
+	signature := webhook.Sign(testPolkaSecret, time.Now(), body)
+
+	if rec := postPolka(t, cfg, signature, body); rec.Code != http.StatusNoContent {
+		t.Fatalf("first delivery: status %d: %s", rec.Code, rec.Body)
+	}
+	// The sender didn't see the response and sends the same delivery again
+	if rec := postPolka(t, cfg, signature, body); rec.Code != http.StatusNoContent {
+		t.Errorf("exact redelivery: status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	events, err := cfg.DB.GetWebhookEvents("", 0, 10)
+	if err != nil {
+		t.Fatalf("GetWebhookEvents: %v", err)
+	}
+	if len(events) != 1 || events[0].Attempts != 1 {
+		t.Errorf("events = %+v, want one event processed once", events)
+	}
+}
+
+func TestPolkaWebhookReplayOfUnprocessedEvent(t *testing.T) {
+	cfg := newPolkaTestConfig(t)
+
+	// No such user, so the event fails and stays unprocessed
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":42}}`)
+	signature := webhook.Sign(testPolkaSecret, time.Now(), body)
+
+	if rec := postPolka(t, cfg, signature, body); rec.Code != http.StatusNotFound {
+		t.Fatalf("first delivery: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := postPolka(t, cfg, signature, body); rec.Code != http.StatusConflict {
+		t.Errorf("exact replay: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	// A retry signed anew is processed again
+	signature = webhook.Sign(testPolkaSecret, time.Now().Add(time.Second), body)
+	if rec := postPolka(t, cfg, signature, body); rec.Code != http.StatusNotFound {
+		t.Errorf("retry: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	events, err := cfg.DB.GetWebhookEvents(database.WebhookFailed, 0, 10)
+	if err != nil {
+		t.Fatalf("GetWebhookEvents: %v", err)
+	}
+	if len(events) != 1 || events[0].Attempts != 2 {
+		t.Errorf("events = %+v, want one failed event attempted twice", events)
+	}
+}
+
+func TestAdminReplayOfProcessedEventNeedsForce(t *testing.T) {
+	cfg := newPolkaTestConfig(t)
+	cfg.adminAPIKey = "admin-key"
+	user, err := cfg.DB.CreateUser("walt@example.com", "hash", "walt")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":` + strconv.Itoa(user.ID) + `}}`)
+	if rec := postPolka(t, cfg, webhook.Sign(testPolkaSecret, time.Now(), body), body); rec.Code != http.StatusNoContent {
+		t.Fatalf("delivery: status %d: %s", rec.Code, rec.Body)
+	}
+	events, err := cfg.DB.GetWebhookEvents("", 0, 1)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("GetWebhookEvents = %v, %v", events, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/webhooks/{deliveryID}/replay", cfg.handlerAdminWebhooksReplay)
+	replay := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/admin/webhooks/"+strconv.Itoa(events[0].ID)+"/replay"+query, nil)
+		req.Header.Set("Authorization", "ApiKey admin-key")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := replay(""); rec.Code != http.StatusConflict {
+		t.Errorf("replay without force: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := replay("?force=true"); rec.Code != http.StatusOK {
+		t.Errorf("replay with force: status %d: %s", rec.Code, rec.Body)
+	}
+	event, err := cfg.DB.GetWebhookEvent(events[0].ID)
+	if err != nil {
+		t.Fatalf("GetWebhookEvent: %v", err)
+	}
+	if event.Attempts != 2 {
+		t.Errorf("Attempts = %d, want 2", event.Attempts)
+	}
+}
+
+func TestPolkaLegacyEventsWithoutIDAreAlwaysProcessed(t *testing.T) {
+	cfg, _ := newTestAPIConfig(t)
+	cfg.polkaAPIKey = "polka-key"
+	user, err := cfg.DB.CreateUser("walt@example.com", "hash", "walt")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	send := func(event string) {
+		t.Helper()
+		body := []byte(`{"event":"` + event + `","data":{"user_id":` + strconv.Itoa(user.ID) + `}}`)
+		req := httptest.NewRequest("POST", "/api/polka/webhooks", bytes.NewReader(body))
+		req.Header.Set("Authorization", "ApiKey polka-key")
+		rec := httptest.NewRecorder()
+		cfg.handlerPolkaWebhooks(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("%s: status %d: %s", event, rec.Code, rec.Body)
+		}
+	}
+	isRed := func() bool {
+		t.Helper()
+		user, err := cfg.DB.GetUserByID(user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		return user.IsChirpyRed()
+	}
+
+	for i, step := range []struct {
+		event string
+		red   bool
+	}{
+		{"user.upgraded", true},
+		{"user.downgraded", false},
+		{"user.upgraded", true},
+	} {
+		send(step.event)
+		if got := isRed(); got != step.red {
+			t.Errorf("after %s #%d: Chirpy Red = %v, want %v", step.event, i+1, got, step.red)
+		}
+	}
+
+	events, err := cfg.DB.GetWebhookEvents(database.WebhookProcessed, 0, 10)
+	if err != nil {
+		t.Fatalf("GetWebhookEvents: %v", err)
+	}
+	if len(events) != 3 {
+		t.Errorf("%d processed events, want 3", len(events))
+	}
+}
@@ -1,24 +1,42 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	auth := r.Header.Get("Authorization")
 	refreshtokenString := strings.TrimPrefix(auth, "Bearer ")
 
-	user, err := cfg.DB.GetUserByRefreshToken(refreshtokenString)
+	newRefreshToken, err := cfg.createRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating refresh token")
+		return
+	}
 
+	token, err := cfg.DB.RotateRefreshToken(
+		hashToken(refreshtokenString),
+		hashToken(newRefreshToken),
+		time.Now().UTC().Add(cfg.config.RefreshTokenTTL),
+		clientInfo(r, ""),
+	)
+	if errors.Is(err, database.ErrTokenReused) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token reuse detected, session revoked")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Invalid token")
 		return
 	}
 
-	userIDStr := strconv.Itoa(user.ID)
-	tokenString, err := cfg.createJWT(userIDStr, 3600)
+	userIDStr := strconv.Itoa(token.UserID)
+	tokenString, err := cfg.createJWT(userIDStr, token.FamilyID, cfg.config.AccessTokenTTL)
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error creating JWT")
@@ -26,9 +44,11 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondWithJSON(w, http.StatusOK, struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}{
-		Token: tokenString,
+		Token:        tokenString,
+		RefreshToken: newRefreshToken,
 	})
 
 }
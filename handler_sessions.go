@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+type Session struct {
+	ID          int       `json:"id"`
+	DeviceLabel string    `json:"device_label"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	Current     bool      `json:"current"`
+}
+
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbSessions, err := cfg.DB.GetSessions(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions")
+		return
+	}
+
+	sessions := []Session{}
+	for _, dbSession := range dbSessions {
+		sessions = append(sessions, Session{
+			ID:          dbSession.ID,
+			DeviceLabel: dbSession.DeviceLabel,
+			IP:          dbSession.IP,
+			UserAgent:   dbSession.UserAgent,
+			CreatedAt:   dbSession.CreatedAt,
+			LastUsedAt:  dbSession.LastUsedAt,
+			Current:     dbSession.ID == user.SessionID,
+		})
+	}
+
+	// Most recently used first
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) handlerSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.Atoi(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	err = cfg.DB.RevokeSession(user.ID, sessionID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsDeleteOthers signs out every device except the one making
+// the request. With ?all=true it signs out everywhere, including the caller,
+// and invalidates every access token issued so far.
+func (cfg *apiConfig) handlerSessionsDeleteOthers(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("all") == "true" {
+		err = cfg.revokeAllUserTokens(user.ID)
+	} else {
+		err = cfg.DB.RevokeOtherSessions(user.ID, user.SessionID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientInfo describes the device making the request. The label falls back to
+// the user agent when the client doesn't provide one.
+func clientInfo(r *http.Request, deviceLabel string) database.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	userAgent := r.UserAgent()
+	if deviceLabel == "" {
+		deviceLabel = userAgent
+	}
+	return database.ClientInfo{
+		DeviceLabel: deviceLabel,
+		IP:          ip,
+		UserAgent:   userAgent,
+	}
+}
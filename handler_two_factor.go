@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/totp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// twoFactorAudience keeps challenge tokens from being used as access
+	// tokens and vice versa
+	twoFactorAudience = "chirpy-2fa"
+	challengeTokenTTL = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+var errInvalidSecondFactor = errors.New("invalid two-factor code")
+
+// challengeClaims are carried by the token handed out after the password
+// check of a two-factor login
+type challengeClaims struct {
+	jwt.RegisteredClaims
+	DeviceLabel string `json:"device_label,omitempty"`
+}
+
+// handlerTwoFactorEnroll starts enrollment by generating a new TOTP secret.
+// Two-factor authentication is only active once confirmed with a code.
+func (cfg *apiConfig) handlerTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	if cfg.totpBox == nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Two-factor authentication is not configured")
+		return
+	}
+
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(caller.ID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if user.TwoFactor.Enabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret")
+		return
+	}
+
+	encryptedSecret, err := cfg.totpBox.Seal(secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encrypt secret")
+		return
+	}
+
+	err = cfg.DB.SetTwoFactorSecret(user.ID, encryptedSecret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store secret")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(secret, "Chirpy", user.Email),
+	})
+}
+
+// handlerTwoFactorConfirm enables two-factor authentication once the user
+// proves their authenticator works, and hands out recovery codes.
+func (cfg *apiConfig) handlerTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	if cfg.totpBox == nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Two-factor authentication is not configured")
+		return
+	}
+
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	params := parameters{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(caller.ID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if user.TwoFactor.Enabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if user.TwoFactor.Secret == "" {
+		respondWithError(w, http.StatusBadRequest, "No pending two-factor enrollment")
+		return
+	}
+
+	secret, err := cfg.totpBox.Open(user.TwoFactor.Secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decrypt secret")
+		return
+	}
+
+	step, ok := totp.Validate(secret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	recoveryCodes := make([]string, 0, recoveryCodeCount)
+	recoveryCodeHashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := createRecoveryCode()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes")
+			return
+		}
+		recoveryCodes = append(recoveryCodes, code)
+		recoveryCodeHashes = append(recoveryCodeHashes, hashRecoveryCode(code))
+	}
+
+	err = cfg.DB.EnableTwoFactor(user.ID, step, recoveryCodeHashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// handlerTwoFactorDisable turns two-factor authentication off. It requires a
+// current code or a recovery code.
+func (cfg *apiConfig) handlerTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	params := parameters{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(caller.ID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if !user.TwoFactor.Enabled {
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+
+	err = cfg.verifySecondFactor(user, params.Code, params.RecoveryCode)
+	if errors.Is(err, errInvalidSecondFactor) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify code")
+		return
+	}
+
+	err = cfg.DB.DisableTwoFactor(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLoginTwoFactor completes a two-factor login by exchanging the
+// challenge token and a code for the usual login tokens.
+func (cfg *apiConfig) handlerLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	params := parameters{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	claims, err := cfg.validateChallengeToken(params.ChallengeToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid challenge token")
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid challenge token")
+		return
+	}
+
+	// Checked up front so a replayed challenge doesn't burn a recovery code
+	used, err := cfg.DB.TwoFactorChallengeUsed(claims.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify challenge token")
+		return
+	}
+	if used {
+		respondWithError(w, http.StatusUnauthorized, "Invalid challenge token")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid challenge token")
+		return
+	}
+
+	if user.Suspended {
+		respondWithError(w, http.StatusForbidden, "Account suspended")
+		return
+	}
+
+	// Codes are short, so guessing them is throttled like passwords
+	if !cfg.checkLoginThrottle(w, r, user.Email) {
+		return
+	}
+
+	err = cfg.verifySecondFactor(user, params.Code, params.RecoveryCode)
+	if errors.Is(err, errInvalidSecondFactor) {
+		cfg.recordLoginFailure(r, user.Email)
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify code")
+		return
+	}
+	cfg.clearLoginFailures(user.Email)
+
+	// A challenge completes one login; a wrong code doesn't spend it. This
+	// also catches a concurrent request that passed the check above.
+	err = cfg.DB.UseTwoFactorChallenge(claims.ID, claims.ExpiresAt.Time)
+	if errors.Is(err, database.ErrTokenRevoked) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid challenge token")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify challenge token")
+		return
+	}
+
+	cfg.respondWithLoginTokens(w, r, user, claims.DeviceLabel)
+}
+
+// verifySecondFactor accepts either a TOTP code, which can only be used once
+// per time step, or an unused recovery code, which is consumed
+func (cfg *apiConfig) verifySecondFactor(user database.User, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		err := cfg.DB.UseRecoveryCode(user.ID, hashRecoveryCode(recoveryCode))
+		if errors.Is(err, database.ErrNotExist) {
+			return errInvalidSecondFactor
+		}
+		return err
+	}
+
+	if cfg.totpBox == nil {
+		return errors.New("two-factor authentication is not configured")
+	}
+
+	secret, err := cfg.totpBox.Open(user.TwoFactor.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return errInvalidSecondFactor
+	}
+
+	err = cfg.DB.UseTwoFactorStep(user.ID, step)
+	if errors.Is(err, database.ErrCodeUsed) {
+		return errInvalidSecondFactor
+	}
+	return err
+}
+
+// createChallengeToken creates the short-lived token that proves the password
+// step of a two-factor login succeeded
+func (cfg *apiConfig) createChallengeToken(userID int, deviceLabel string) (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("failed to generate challenge ID: %w", err)
+	}
+
+	now := time.Now().UTC()
+	claims := &challengeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{twoFactorAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(challengeTokenTTL)),
+			Subject:   strconv.Itoa(userID),
+			ID:        hex.EncodeToString(idBytes),
+		},
+		DeviceLabel: deviceLabel,
+	}
+
+	tokenString, err := cfg.jwtKeys.Sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the challenge token: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+func (cfg *apiConfig) validateChallengeToken(tokenString string) (*challengeClaims, error) {
+	claims := &challengeClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, cfg.jwtKeys.Keyfunc,
+		jwt.WithValidMethods(cfg.jwtKeys.Algorithms()),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithAudience(twoFactorAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" {
+		return nil, jwt.ErrTokenRequiredClaimMissing
+	}
+	return claims, nil
+}
+
+// createRecoveryCode generates a one-time recovery code like "a1b2c-3d4e5"
+func createRecoveryCode() (string, error) {
+	codeBytes := make([]byte, 5)
+	if _, err := rand.Read(codeBytes); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+	code := hex.EncodeToString(codeBytes)
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode hashes a recovery code after normalizing how it was typed
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return hashToken(code)
+}
@@ -1,22 +1,24 @@
 package main
 
 import (
-	"encoding/json"
-	"golang.org/x/crypto/bcrypt"
+	"errors"
 	"net/http"
+	"strings"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
 	type User struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Handle   string `json:"handle"`
 	}
 
 	var u User = User{}
 
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&u); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+	if err := cfg.decodeJSON(w, r, &u); err != nil {
+		respondWithDecodeError(w, err)
 		return
 	}
 
@@ -25,28 +27,71 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := validateEmail(u.Email); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u.Handle = strings.TrimPrefix(u.Handle, "@")
+	if u.Handle != "" {
+		if err := validateHandle(u.Handle); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	if u.Password == "" {
 		respondWithError(w, http.StatusBadRequest, "Password is required")
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	if err := cfg.passwordPolicy.Validate(u.Password); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := cfg.DB.GetUserByEmail(u.Email); err == nil {
+		respondWithError(w, http.StatusConflict, "Email is already registered")
+		return
+	}
+
+	hashedPassword, err := cfg.passwords.Hash(u.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		return
+	}
 
-	user, err := cfg.DB.CreateUser(u.Email, string(hashedPassword))
+	user, err := cfg.DB.CreateUser(u.Email, hashedPassword, u.Handle)
 
+	// The early check can race with a concurrent signup
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, "Email is already registered")
+		return
+	}
+	if errors.Is(err, database.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, "Handle is already taken")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user")
 		return
 	}
 
+	err = cfg.sendVerificationEmail(user)
+	if err != nil {
+		cfg.requestLogger(r).Error("Couldn't send verification email", "err", err)
+	}
+
 	respondWithJSON(w, http.StatusCreated, struct {
 		Email       string `json:"email"`
 		ID          int    `json:"id"`
+		Handle      string `json:"handle,omitempty"`
 		IsChirpyRed bool   `json:"is_chirpy_red"`
 	}{
 		Email:       user.Email,
 		ID:          user.ID,
-		IsChirpyRed: user.IsChirpyRed,
+		Handle:      user.Handle,
+		IsChirpyRed: user.IsChirpyRed(),
 	})
 
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/mailer"
+)
+
+// handlerUsersDelete deletes the caller's account after they confirm their
+// password, and their second factor if they use one. Their chirps are deleted
+// or anonymized depending on DELETED_USER_CHIRPS.
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	params := parameters{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(caller.ID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	// A stolen access token shouldn't allow guessing the password here
+	// faster than at login
+	if !cfg.checkLoginThrottle(w, r, user.Email) {
+		return
+	}
+	if cfg.passwords.Verify(params.Password, user.Password) != nil {
+		cfg.recordLoginFailure(r, user.Email)
+		respondWithError(w, http.StatusUnauthorized, "Password is incorrect")
+		return
+	}
+
+	if user.TwoFactor.Enabled {
+		err = cfg.verifySecondFactor(user, params.Code, params.RecoveryCode)
+		if errors.Is(err, errInvalidSecondFactor) {
+			cfg.recordLoginFailure(r, user.Email)
+			respondWithError(w, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't verify code")
+			return
+		}
+	}
+
+	orphaned, err := cfg.DB.DeleteUser(user.ID, cfg.anonymizeDeletedChirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account")
+		return
+	}
+	for _, img := range orphaned {
+		cfg.deleteImage(img)
+	}
+	cfg.notificationHub.disconnect(user.ID)
+	cfg.clearLoginFailures(user.Email)
+
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account was deleted",
+		Body: "Your Chirpy account and the data stored with it have been deleted.\n\n" +
+			"If you didn't do this, reply to this email.\n",
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
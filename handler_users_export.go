@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/blobstore"
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+const exportReadme = `This archive holds everything Chirpy stores about your account.
+
+account.json                 your account, without your password hash and
+                             two-factor secrets
+chirps.json                  your chirps
+media.json, media/           images you uploaded and your avatar
+followers.json               who follows you
+following.json               who you follow
+sessions.json                devices you are logged in on
+personal_access_tokens.json  your access tokens, without the tokens themselves
+email_tokens.json            verification and reset emails sent to you
+webhook_endpoints.json       webhook endpoints you registered, without their
+                             signing secrets
+notifications.json           your notifications
+webhook_events.json          Polka payment events about your account, which
+                             set your Chirpy Red subscription
+login_throttles.json         failed logins and password reset requests
+                             counted against your email address
+
+Login and password reset attempts counted against IP addresses are left
+out, since they aren't tied to an account.
+`
+
+// handlerUsersExport responds with a zip archive of the caller's data.
+func (cfg *apiConfig) handlerUsersExport(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	export, err := cfg.DB.ExportUser(caller.ID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't export data")
+		return
+	}
+	export.WebhookEvents, err = cfg.DB.FindWebhookEvents(polkaSource, func(event database.WebhookEvent) bool {
+		var webhook PolkaWebhook
+		return json.Unmarshal(event.Payload, &webhook) == nil && webhook.Data.UserID == caller.ID
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't export data")
+		return
+	}
+	email := accountThrottleKey(export.User.Email)
+	export.LoginThrottles, err = cfg.DB.GetLoginThrottles(email, resetThrottleKey(email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't export data")
+		return
+	}
+
+	filename := fmt.Sprintf("chirpy-export-%d-%s.zip", caller.ID, time.Now().UTC().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent, so failures past this point can only
+	// truncate the archive
+	if err := cfg.writeExport(w, export); err != nil {
+		cfg.requestLogger(r).Error("Couldn't write export", "user_id", caller.ID, "err", err)
+	}
+}
+
+func (cfg *apiConfig) writeExport(w io.Writer, export database.UserExport) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"account.json", export.User},
+		{"chirps.json", export.Chirps},
+		{"media.json", export.Media},
+		{"followers.json", export.Followers},
+		{"following.json", export.Following},
+		{"sessions.json", export.Sessions},
+		{"personal_access_tokens.json", export.PersonalAccessTokens},
+		{"email_tokens.json", export.EmailTokens},
+		{"webhook_endpoints.json", export.WebhookEndpoints},
+		{"notifications.json", export.Notifications},
+		{"webhook_events.json", export.WebhookEvents},
+		{"login_throttles.json", export.LoginThrottles},
+	}
+
+	readme, err := archive.Create("README.txt")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(readme, exportReadme); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return err
+		}
+	}
+
+	images := []database.Image{}
+	if export.User.Avatar != nil {
+		images = append(images, *export.User.Avatar)
+	}
+	for _, media := range export.Media {
+		images = append(images, media.Image)
+	}
+	for _, img := range images {
+		if err := cfg.copyBlob(archive, "media/"+img.Key, img.Key); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// copyBlob adds a stored blob to the archive. Missing blobs are skipped.
+func (cfg *apiConfig) copyBlob(archive *zip.Writer, name, key string) error {
+	blob, err := cfg.blobs.Open(key)
+	if errors.Is(err, blobstore.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	f, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store, // images are already compressed
+		Modified: blob.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, blob)
+	return err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+func TestUsersExportIncludesEventsAndThrottles(t *testing.T) {
+	cfg, _ := newTestAPIConfig(t)
+	user, err := cfg.DB.CreateUser("xena@example.com", "hash", "xena")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	other, err := cfg.DB.CreateUser("yuri@example.com", "hash", "yuri")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	for i, userID := range []int{user.ID, other.ID} {
+		payload := `{"event":"user.upgraded","data":{"user_id":` + strconv.Itoa(userID) + `}}`
+		if _, _, err := cfg.DB.RecordWebhookEvent(polkaSource, "evt-"+strconv.Itoa(i), "user.upgraded", []byte(payload)); err != nil {
+			t.Fatalf("RecordWebhookEvent: %v", err)
+		}
+	}
+	for _, key := range []string{accountThrottleKey(user.Email), resetThrottleKey(accountThrottleKey(user.Email)), accountThrottleKey(other.Email)} {
+		if _, err := cfg.DB.RecordLoginFailure(key, time.Hour, accountLoginLimits.lockFor); err != nil {
+			t.Fatalf("RecordLoginFailure: %v", err)
+		}
+	}
+	token, err := cfg.createJWT(strconv.Itoa(user.ID), 0, time.Hour)
+	if err != nil {
+		t.Fatalf("createJWT: %v", err)
+	}
+
+	rec := doJSON(t, http.HandlerFunc(cfg.handlerUsersExport), "GET", "/api/users/me/export", token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+	readFile := func(name string, v any) {
+		t.Helper()
+		f, err := archive.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%q): %v", name, err)
+		}
+		defer f.Close()
+		if err := json.NewDecoder(f).Decode(v); err != nil {
+			t.Fatalf("decoding %s: %v", name, err)
+		}
+	}
+
+	var events []database.WebhookEvent
+	readFile("webhook_events.json", &events)
+	if len(events) != 1 || events[0].EventID != "evt-0" {
+		t.Errorf("webhook events = %+v, want only evt-0", events)
+	}
+
+	var throttles map[string]database.LoginThrottle
+	readFile("login_throttles.json", &throttles)
+	if len(throttles) != 2 || throttles["email:xena@example.com"].Failures != 1 || throttles["reset:email:xena@example.com"].Failures != 1 {
+		t.Errorf("login throttles = %+v, want xena's login and reset records", throttles)
+	}
+}
+
+func TestUsersGetOmitsUnknownJoinDate(t *testing.T) {
+	cfg, _ := newTestAPIConfig(t)
+
+	// Accounts from before join dates were recorded have no created_at
+	path := filepath.Join(t.TempDir(), "database.json")
+	legacy := `{"users":{"1":{"id":1,"email":"zed@example.com","handle":"zed"}}}`
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	db, err := database.NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	cfg.DB = db
+	user, err := db.CreateUser("amy@example.com", "hash", "amy")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/users/{idOrHandle}", cfg.handlerUsersGet)
+	for _, tc := range []struct {
+		handle string
+		joined bool
+	}{
+		{"zed", false},
+		{user.Handle, true},
+	} {
+		rec := doJSON(t, mux, "GET", "/api/users/"+tc.handle, "", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want %d: %s", tc.handle, rec.Code, http.StatusOK, rec.Body)
+		}
+		if joined := strings.Contains(rec.Body.String(), `"joined_at"`); joined != tc.joined {
+			t.Errorf("%s: profile %s, want joined_at present = %v", tc.handle, rec.Body, tc.joined)
+		}
+	}
+}
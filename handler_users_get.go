@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+// Handles start with a letter, so they can't be mistaken for user IDs
+var handlePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{2,14}$`)
+
+// reservedHandles would clash with routes or impersonate staff
+var reservedHandles = []string{"admin", "api", "app", "chirpy", "root", "support"}
+
+// PublicProfile is what anyone can see about a user. It never includes the
+// email. JoinedAt is left out for accounts created before join dates were
+// recorded.
+type PublicProfile struct {
+	ID             int        `json:"id"`
+	Handle         string     `json:"handle,omitempty"`
+	DisplayName    string     `json:"display_name"`
+	Bio            string     `json:"bio"`
+	JoinedAt       *time.Time `json:"joined_at,omitempty"`
+	IsChirpyRed    bool       `json:"is_chirpy_red"`
+	FollowersCount int        `json:"followers_count"`
+	FollowingCount int        `json:"following_count"`
+	ChirpsCount    int        `json:"chirps_count"`
+
+	AvatarURL          string `json:"avatar_url,omitempty"`
+	AvatarThumbnailURL string `json:"avatar_thumbnail_url,omitempty"`
+}
+
+func (cfg *apiConfig) handlerUsersGet(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.lookupUser(r.PathValue("idOrHandle"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	stats, err := cfg.DB.GetUserStats(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+		return
+	}
+
+	var joinedAt *time.Time
+	if !user.CreatedAt.IsZero() {
+		joinedAt = &user.CreatedAt
+	}
+
+	avatarURL, avatarThumbnailURL := avatarURLs(user)
+	respondWithJSON(w, http.StatusOK, PublicProfile{
+		ID:             user.ID,
+		Handle:         user.Handle,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		JoinedAt:       joinedAt,
+		IsChirpyRed:    user.IsChirpyRed(),
+		FollowersCount: stats.Followers,
+		FollowingCount: stats.Following,
+		ChirpsCount:    stats.Chirps,
+
+		AvatarURL:          avatarURL,
+		AvatarThumbnailURL: avatarThumbnailURL,
+	})
+}
+
+// lookupUser finds a user by numeric ID or by handle, with or without the
+// leading "@"
+func (cfg *apiConfig) lookupUser(idOrHandle string) (database.User, error) {
+	if id, err := strconv.Atoi(idOrHandle); err == nil {
+		return cfg.DB.GetUserByID(id)
+	}
+	handle := strings.TrimPrefix(idOrHandle, "@")
+	if handle == "" {
+		return database.User{}, database.ErrNotExist
+	}
+	return cfg.DB.GetUserByHandle(handle)
+}
+
+func validateHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return errors.New("Handle must be 3 to 15 letters, digits or underscores, starting with a letter")
+	}
+	if slices.Contains(reservedHandles, strings.ToLower(handle)) {
+		return errors.New("Handle is reserved")
+	}
+	return nil
+}
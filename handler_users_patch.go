@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/mailer"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	emailChangeTTL       = 24 * time.Hour
+)
+
+// UserProfile is the caller's own profile, including private fields
+type UserProfile struct {
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Handle        string `json:"handle,omitempty"`
+	DisplayName   string `json:"display_name"`
+	Bio           string `json:"bio"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	AvatarURL     string `json:"avatar_url,omitempty"`
+	// ChirpyRedSince and ChirpyRedUntil bound an active Chirpy Red
+	// subscription. ChirpyRedUntil is unset for open-ended ones.
+	ChirpyRedSince *time.Time `json:"chirpy_red_since,omitempty"`
+	ChirpyRedUntil *time.Time `json:"chirpy_red_until,omitempty"`
+	// AvatarThumbnailURL is a small square version of the avatar
+	AvatarThumbnailURL string `json:"avatar_thumbnail_url,omitempty"`
+	// PendingEmail is set while a change of email awaits confirmation
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
+// handlerUsersPatch updates only the fields present in the body. Changing the
+// password requires the current one, and a new email only takes effect once
+// confirmed through a link sent to it.
+func (cfg *apiConfig) handlerUsersPatch(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Handle          *string `json:"handle"`
+		DisplayName     *string `json:"display_name"`
+		Bio             *string `json:"bio"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	params := parameters{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(caller.ID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	// Personal access tokens may edit the profile but not take over the
+	// account
+	if (params.Email != nil || params.Password != nil) && caller.Scopes != nil {
+		respondWithError(w, http.StatusForbidden, "Changing email or password requires logging in")
+		return
+	}
+
+	if params.Handle != nil {
+		handle := strings.TrimPrefix(*params.Handle, "@")
+		if err := validateHandle(handle); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		params.Handle = &handle
+	}
+
+	if params.DisplayName != nil {
+		trimmed := strings.TrimSpace(*params.DisplayName)
+		if utf8.RuneCountInString(trimmed) > maxDisplayNameLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Display name must be at most %d characters", maxDisplayNameLength))
+			return
+		}
+		params.DisplayName = &trimmed
+	}
+
+	if params.Bio != nil && utf8.RuneCountInString(*params.Bio) > maxBioLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Bio must be at most %d characters", maxBioLength))
+		return
+	}
+
+	if params.Email != nil && *params.Email == user.Email {
+		params.Email = nil
+	}
+	if params.Email != nil {
+		if err := validateEmail(*params.Email); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if other, err := cfg.DB.GetUserByEmail(*params.Email); err == nil && other.ID != user.ID {
+			respondWithError(w, http.StatusConflict, "Email is already registered")
+			return
+		}
+	}
+
+	var newPasswordHash string
+	if params.Password != nil {
+		if cfg.passwords.Verify(params.CurrentPassword, user.Password) != nil {
+			respondWithError(w, http.StatusUnauthorized, "Current password is incorrect")
+			return
+		}
+		if err := cfg.passwordPolicy.Validate(*params.Password); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		newPasswordHash, err = cfg.passwords.Hash(*params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+	}
+
+	if params.Handle != nil && *params.Handle != user.Handle {
+		user, err = cfg.DB.SetUserHandle(user.ID, *params.Handle)
+		if errors.Is(err, database.ErrHandleTaken) {
+			respondWithError(w, http.StatusConflict, "Handle is already taken")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to update handle")
+			return
+		}
+	}
+
+	if params.DisplayName != nil || params.Bio != nil {
+		user, err = cfg.DB.UpdateUserProfile(user.ID, params.DisplayName, params.Bio)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to update user")
+			return
+		}
+	}
+
+	profile := newUserProfile(user)
+
+	if params.Email != nil {
+		err = cfg.requestEmailChange(user.ID, *params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create confirmation token")
+			return
+		}
+		profile.PendingEmail = *params.Email
+	}
+
+	if params.Password != nil {
+		err = cfg.changePassword(user.ID, newPasswordHash)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to update password")
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+// requestEmailChange mails a confirmation link to the new address. The
+// account keeps its current email until the link is followed.
+func (cfg *apiConfig) requestEmailChange(userID int, email string) error {
+	token, err := cfg.createEmailToken(userID, email, database.EmailTokenChangeEmail, emailChangeTTL)
+	if err != nil {
+		return err
+	}
+	cfg.sendMail(mailer.Message{
+		To:      email,
+		Subject: "Confirm your new Chirpy email",
+		Body: fmt.Sprintf("Someone asked to change the email of a Chirpy account to this address.\n\n"+
+			"Confirm it at %s/app/confirm-email?token=%s\n\n"+
+			"The link expires in 24 hours. Until then the account keeps using its current email.\n",
+			cfg.publicURL, token),
+	})
+	return nil
+}
+
+// changePassword stores the new password hash. A new password signs the
+// user out everywhere.
+func (cfg *apiConfig) changePassword(userID int, passwordHash string) error {
+	err := cfg.DB.UpdateUserPassword(userID, passwordHash)
+	if err != nil {
+		return err
+	}
+	return cfg.revokeAllUserTokens(userID)
+}
+
+// revokeAllUserTokens signs the user out everywhere and closes their
+// notification sockets.
+func (cfg *apiConfig) revokeAllUserTokens(userID int) error {
+	err := cfg.DB.RevokeAllUserTokens(userID)
+	if err != nil {
+		return err
+	}
+	cfg.notificationHub.disconnect(userID)
+	return nil
+}
+
+// handlerUsersEmailConfirm switches the account to the address a change of
+// email token was sent to
+func (cfg *apiConfig) handlerUsersEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	params := parameters{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	token, err := cfg.DB.UseEmailToken(database.EmailTokenChangeEmail, hashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+
+	user, err := cfg.DB.ChangeUserEmail(token.UserID, token.Email)
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, "Email is already registered")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change email")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newUserProfile(user))
+}
+
+func newUserProfile(user database.User) UserProfile {
+	avatarURL, avatarThumbnailURL := avatarURLs(user)
+	profile := UserProfile{
+		ID:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Handle:        user.Handle,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		IsChirpyRed:   user.IsChirpyRed(),
+
+		AvatarURL:          avatarURL,
+		AvatarThumbnailURL: avatarThumbnailURL,
+	}
+	if user.IsChirpyRed() {
+		profile.ChirpyRedSince = &user.Subscription.StartedAt
+		profile.ChirpyRedUntil = user.Subscription.ExpiresAt
+	}
+	return profile
+}
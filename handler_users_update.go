@@ -1,32 +1,28 @@
 package main
 
 import (
-	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
 
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// handlerUsersUpdate replaces the caller's email and password. It is kept
+// as it was for existing clients and marked deprecated in favour of PATCH,
+// which confirms new emails and asks for the current password.
 func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request) {
-	auth := r.Header.Get("Authorization")
-	tokenString := strings.TrimPrefix(auth, "Bearer ")
-
-	// Log the token string
-	log.Printf("Token string: %s", tokenString)
-
-	claims, err := cfg.validateJWT(tokenString)
+	// Replacing the email and password takes over the account, so personal
+	// access tokens can't do it whatever their scopes
+	caller, err := cfg.authenticate(r, "")
 	if err != nil {
-		log.Printf("Token validation error: %v", err)
-		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		respondWithAuthError(w, err)
 		return
 	}
 
-	// Log the claims
-	log.Printf("Claims: %+v", claims)
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", `</api/users>; rel="successor-version"; title="PATCH /api/users"`)
 
 	type User struct {
 		Email    string `json:"email"`
@@ -35,9 +31,8 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 
 	var u User
 
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&u); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+	if err := cfg.decodeJSON(w, r, &u); err != nil {
+		respondWithDecodeError(w, err)
 		return
 	}
 
@@ -51,27 +46,48 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Log the subject before conversion
-	log.Printf("Claims subject before conversion: %s", claims.Subject)
+	id := caller.ID
 
-	id, err := strconv.Atoi(claims.Subject)
+	user, err := cfg.DB.GetUserByID(id)
 	if err != nil {
-		log.Printf("Error converting subject to int: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to update user (invalid ID)")
+		respondWithError(w, http.StatusNotFound, "User not found")
 		return
 	}
 
-	// hashed password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
-
-	_, err = cfg.DB.UpdateUser(id, u.Email, string(hashedPassword))
+	// Only new passwords have to meet the policy and be hashed again
+	passwordChanged := cfg.passwords.Verify(u.Password, user.Password) != nil
+	hashedPassword := user.Password
+	if passwordChanged {
+		if err := cfg.passwordPolicy.Validate(u.Password); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		hashedPassword, err = cfg.passwords.Hash(u.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+	}
 
+	_, err = cfg.DB.UpdateUser(id, u.Email, hashedPassword)
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, "Email is already registered")
+		return
+	}
 	if err != nil {
-		// Handle error
 		respondWithError(w, http.StatusInternalServerError, "Failed to update user")
 		return
 	}
 
+	// A new password signs the user out everywhere
+	if passwordChanged {
+		err = cfg.revokeAllUserTokens(id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to revoke existing tokens")
+			return
+		}
+	}
+
 	respondWithJSON(w, http.StatusOK, struct {
 		Email string `json:"email"`
 		ID    int    `json:"id"`
@@ -81,21 +97,42 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-func (cfg *apiConfig) validateJWT(tokenString string) (*jwt.RegisteredClaims, error) {
-	claims := &jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.jwtSecret), nil
-	})
+// validateJWT parses and verifies an access token. Besides the signature it
+// pins the algorithm to those in the key ring, requires our issuer, audience,
+// expiry and token ID, and rejects tokens revoked through the user's
+// watermark or their session.
+func (cfg *apiConfig) validateJWT(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, cfg.jwtKeys.Keyfunc,
+		jwt.WithValidMethods(cfg.jwtKeys.Algorithms()),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithAudience(jwtAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 
 	if err != nil {
-		log.Printf("Error parsing token: %v", err)
+		cfg.logger.Debug("Rejected access token", "err", err)
 		return nil, err
 	}
 
 	if !token.Valid {
-		log.Println("Token is invalid")
 		return nil, jwt.ErrSignatureInvalid
 	}
 
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, jwt.ErrTokenRequiredClaimMissing
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, jwt.ErrTokenInvalidSubject
+	}
+
+	err = cfg.DB.CheckAccessToken(userID, claims.SessionID, claims.IssuedAt.Time)
+	if err != nil {
+		return nil, err
+	}
+
 	return claims, nil
 }
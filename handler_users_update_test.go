@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUsersUpdateKeepsItsContract(t *testing.T) {
+	cfg, _ := newTestAPIConfig(t)
+	mux := newEmailTestMux(cfg)
+	mux.HandleFunc("PUT /api/users", cfg.handlerUsersUpdate)
+
+	rec := doJSON(t, mux, "POST", "/api/users", "", credentials{"walt@example.com", "correct horse"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("signup: status %d: %s", rec.Code, rec.Body)
+	}
+	rec = doJSON(t, mux, "POST", "/api/login", "", credentials{"walt@example.com", "correct horse"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+	}
+	var login struct {
+		Token string `json:"token"`
+	}
+	decodeBody(t, rec, &login)
+
+	// Clients written before PATCH send only the new email and password
+	rec = doJSON(t, mux, "PUT", "/api/users", login.Token, credentials{"heisenberg@example.com", "correct horse"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Deprecation") == "" {
+		t.Error("no Deprecation header")
+	}
+	var updated struct {
+		Email        string  `json:"email"`
+		PendingEmail *string `json:"pending_email"`
+	}
+	decodeBody(t, rec, &updated)
+	if updated.Email != "heisenberg@example.com" || updated.PendingEmail != nil {
+		t.Errorf("response = %s, want the new email and no pending one", rec.Body)
+	}
+
+	user, err := cfg.DB.GetUserByEmail("heisenberg@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if user.EmailVerified {
+		t.Error("new email is marked verified")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/webhook"
+)
+
+const maxWebhookEndpointsPerUser = 5
+
+// adminEndpointOwner owns the endpoints registered by admins, which receive
+// every event
+const adminEndpointOwner = 0
+
+type WebhookEndpoint struct {
+	ID         int        `json:"id"`
+	URL        string     `json:"url"`
+	Events     []string   `json:"events"`
+	Enabled    bool       `json:"enabled"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Secret is only returned once, when the endpoint is created
+	Secret string `json:"secret,omitempty"`
+}
+
+type OutboundDelivery struct {
+	ID             int        `json:"id"`
+	EventID        string     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsCreate(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	endpoints, err := cfg.DB.GetWebhookEndpoints(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhook endpoints")
+		return
+	}
+	if len(endpoints) >= maxWebhookEndpointsPerUser {
+		respondWithError(w, http.StatusBadRequest, "You can register at most "+strconv.Itoa(maxWebhookEndpointsPerUser)+" webhook endpoints")
+		return
+	}
+
+	cfg.createWebhookEndpoint(w, r, user.ID)
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsList(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.listWebhookEndpoints(w, user.ID)
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsDelete(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.deleteWebhookEndpoint(w, r, user.ID)
+}
+
+// handlerWebhookEndpointsEnable re-enables an endpoint that was disabled
+// after failing repeatedly. Deliveries that failed meanwhile aren't retried.
+func (cfg *apiConfig) handlerWebhookEndpointsEnable(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.enableWebhookEndpoint(w, r, user.ID)
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsDeliveries(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.listOutboundDeliveries(w, r, user.ID)
+}
+
+func (cfg *apiConfig) handlerAdminWebhookEndpointsCreate(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	cfg.createWebhookEndpoint(w, r, adminEndpointOwner)
+}
+
+func (cfg *apiConfig) handlerAdminWebhookEndpointsList(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	cfg.listWebhookEndpoints(w, adminEndpointOwner)
+}
+
+func (cfg *apiConfig) handlerAdminWebhookEndpointsDelete(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	cfg.deleteWebhookEndpoint(w, r, adminEndpointOwner)
+}
+
+func (cfg *apiConfig) handlerAdminWebhookEndpointsEnable(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	cfg.enableWebhookEndpoint(w, r, adminEndpointOwner)
+}
+
+func (cfg *apiConfig) handlerAdminWebhookEndpointsDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	cfg.listOutboundDeliveries(w, r, adminEndpointOwner)
+}
+
+func (cfg *apiConfig) createWebhookEndpoint(w http.ResponseWriter, r *http.Request, ownerID int) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	params := parameters{}
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if err := webhook.ValidateURL(params.URL, cfg.webhookAllowInsecure); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook URL: "+err.Error())
+		return
+	}
+
+	if len(params.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one event is required")
+		return
+	}
+	for _, event := range params.Events {
+		if !slices.Contains(validEventTypes, event) {
+			respondWithError(w, http.StatusBadRequest, "Unknown event: "+event)
+			return
+		}
+	}
+	events := slices.Clone(params.Events)
+	slices.Sort(events)
+	events = slices.Compact(events)
+
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating signing secret")
+		return
+	}
+	secret := "whsec_" + hex.EncodeToString(secretBytes)
+
+	endpoint, err := cfg.DB.CreateWebhookEndpoint(ownerID, params.URL, secret, events)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook endpoint")
+		return
+	}
+
+	response := newWebhookEndpoint(endpoint)
+	response.Secret = secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) listWebhookEndpoints(w http.ResponseWriter, ownerID int) {
+	dbEndpoints, err := cfg.DB.GetWebhookEndpoints(ownerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhook endpoints")
+		return
+	}
+
+	endpoints := []WebhookEndpoint{}
+	for _, dbEndpoint := range dbEndpoints {
+		endpoints = append(endpoints, newWebhookEndpoint(dbEndpoint))
+	}
+
+	respondWithJSON(w, http.StatusOK, endpoints)
+}
+
+func (cfg *apiConfig) deleteWebhookEndpoint(w http.ResponseWriter, r *http.Request, ownerID int) {
+	endpointID, err := strconv.Atoi(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID")
+		return
+	}
+
+	err = cfg.DB.DeleteWebhookEndpoint(ownerID, endpointID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook endpoint")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) enableWebhookEndpoint(w http.ResponseWriter, r *http.Request, ownerID int) {
+	endpointID, err := strconv.Atoi(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID")
+		return
+	}
+
+	endpoint, err := cfg.DB.EnableWebhookEndpoint(ownerID, endpointID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable webhook endpoint")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newWebhookEndpoint(endpoint))
+}
+
+// listOutboundDeliveries responds with the latest deliveries to an endpoint,
+// newest first, up to the limit query parameter.
+func (cfg *apiConfig) listOutboundDeliveries(w http.ResponseWriter, r *http.Request, ownerID int) {
+	endpointID, err := strconv.Atoi(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID")
+		return
+	}
+
+	limit := defaultWebhookPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxWebhookPageSize {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxWebhookPageSize))
+			return
+		}
+	}
+
+	dbDeliveries, err := cfg.DB.GetOutboundDeliveries(ownerID, endpointID, limit)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve deliveries")
+		return
+	}
+
+	deliveries := []OutboundDelivery{}
+	for _, dbDelivery := range dbDeliveries {
+		deliveries = append(deliveries, newOutboundDelivery(dbDelivery))
+	}
+
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+func newWebhookEndpoint(endpoint database.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:         endpoint.ID,
+		URL:        endpoint.URL,
+		Events:     endpoint.Events,
+		Enabled:    endpoint.DisabledAt == nil,
+		DisabledAt: endpoint.DisabledAt,
+		CreatedAt:  endpoint.CreatedAt,
+	}
+}
+
+func newOutboundDelivery(delivery database.OutboundDelivery) OutboundDelivery {
+	resp := OutboundDelivery{
+		ID:             delivery.ID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		CreatedAt:      delivery.CreatedAt,
+		DeliveredAt:    delivery.DeliveredAt,
+	}
+	if delivery.Status == database.DeliveryPending {
+		resp.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	return resp
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/config"
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/keyring"
+	"github.com/Chaitanya-Shahare/chirpy/internal/mailer"
+	"github.com/Chaitanya-Shahare/chirpy/internal/password"
+)
+
+// newTestAPIConfig returns a config backed by a fresh database, with mail
+// kept in memory and password hashing cheap enough for tests.
+func newTestAPIConfig(t *testing.T) (*apiConfig, *mailer.MemoryMailer) {
+	t.Helper()
+
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	conf := config.Default()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mail := &mailer.MemoryMailer{}
+	params := password.DefaultParams
+	params.Memory = 64
+	params.Iterations = 1
+	params.Parallelism = 1
+
+	cfg := &apiConfig{
+		metrics:         newServerMetrics(),
+		DB:              db,
+		jwtKeys:         keyring.NewHMAC("test-secret"),
+		mailer:          mail,
+		publicURL:       "http://chirpy.test",
+		passwords:       &password.Hasher{Params: params},
+		passwordPolicy:  &password.Policy{MinLength: 8, MaxLength: 1024},
+		notificationHub: newNotificationHub(logger),
+		config:          &conf,
+		logger:          logger,
+	}
+	dummyPasswordHash, err := cfg.passwords.Hash("chirpy-dummy-password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	cfg.dummyPasswordHash = dummyPasswordHash
+
+	return cfg, mail
+}
+
+// doJSON sends body, encoded as JSON, to handler and returns the response.
+// A non-empty token is sent as a bearer token.
+func doJSON(t *testing.T, handler http.Handler, method, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reqBody io.Reader = http.NoBody
+	if body != nil {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		reqBody = bytes.NewReader(dat)
+	}
+	req := httptest.NewRequest(method, path, reqBody)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeBody decodes the JSON response into dst.
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, dst any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), dst); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+// mailedToken waits for queued mail and returns the token from the latest
+// link to page sent to email. Mail is sent in the background, so messages
+// may arrive in any order.
+func mailedToken(t *testing.T, cfg *apiConfig, mail *mailer.MemoryMailer, email, page string) string {
+	t.Helper()
+	cfg.background.Wait()
+
+	pattern := regexp.MustCompile(regexp.QuoteMeta(page) + `\?token=([0-9a-f]+)`)
+	messages := mail.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].To != email {
+			continue
+		}
+		if match := pattern.FindStringSubmatch(messages[i].Body); match != nil {
+			return match[1]
+		}
+	}
+	t.Fatalf("no %s link mailed to %s", page, email)
+	return ""
+}
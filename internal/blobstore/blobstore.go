@@ -0,0 +1,118 @@
+package blobstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrNotExist   = errors.New("blob does not exist")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// validKey keeps keys to flat, safe file names so they can't escape the
+// store's directory
+var validKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*(\.[A-Za-z0-9]+)?$`)
+
+// Blob is an open blob. It must be closed after use.
+type Blob interface {
+	io.ReadSeekCloser
+	Size() int64
+	ModTime() time.Time
+}
+
+// BlobStore stores immutable blobs under opaque keys.
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	Open(key string) (Blob, error)
+	// Delete removes a blob. Deleting a missing blob is not an error.
+	Delete(key string) error
+}
+
+// LocalStore keeps blobs as files in a directory on the local disk.
+type LocalStore struct {
+	Dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{Dir: dir}, nil
+}
+
+// Put writes the blob to a temporary file first and renames it into place,
+// so readers never see a partial blob.
+func (s *LocalStore) Put(key string, r io.Reader) error {
+	if !validKey.MatchString(key) {
+		return ErrInvalidKey
+	}
+
+	f, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write blob %s: %w", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(s.Dir, key))
+}
+
+func (s *LocalStore) Open(key string) (Blob, error) {
+	if !validKey.MatchString(key) {
+		return nil, ErrInvalidKey
+	}
+
+	f, err := os.Open(filepath.Join(s.Dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &localBlob{File: f, info: info}, nil
+}
+
+func (s *LocalStore) Delete(key string) error {
+	if !validKey.MatchString(key) {
+		return ErrInvalidKey
+	}
+
+	err := os.Remove(filepath.Join(s.Dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+type localBlob struct {
+	*os.File
+	info os.FileInfo
+}
+
+func (b *localBlob) Size() int64 {
+	return b.info.Size()
+}
+
+func (b *localBlob) ModTime() time.Time {
+	return b.info.ModTime()
+}
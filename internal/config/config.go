@@ -0,0 +1,503 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileEnv names the environment variable that points at a config file when
+// the -config flag isn't given.
+const FileEnv = "CHIRPY_CONFIG"
+
+// Config is the server's configuration. Each setting is read from, in
+// increasing order of precedence, a JSON config file, the environment and
+// command-line flags.
+type Config struct {
+	Port      int
+	DBPath    string
+	FileRoot  string
+	PublicURL string
+	// ShutdownTimeout bounds how long in-flight requests and background
+	// jobs are waited for when the server stops
+	ShutdownTimeout time.Duration
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// MaxBodyBytes caps every request body; routes set lower limits of
+	// their own, like MaxJSONBodyBytes for JSON bodies
+	MaxBodyBytes     int
+	MaxJSONBodyBytes int
+
+	// LogFormat is "text" or "json"
+	LogFormat string
+	LogLevel  string
+	// MetricsPublic serves /metrics without the admin API key, for
+	// scrapers that can't send it
+	MetricsPublic bool
+
+	JWTSecret  string
+	JWTKeysDir string
+	// AccessTokenTTL is how long access tokens stay valid
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is how long a refresh token stays valid if it is not
+	// rotated
+	RefreshTokenTTL time.Duration
+
+	AdminAPIKey         string
+	PolkaAPIKey         string
+	PolkaWebhookSecrets []string
+	TOTPEncryptionKey   string
+
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+	MailDir      string
+
+	PasswordArgon2MemoryKiB   int
+	PasswordArgon2Iterations  int
+	PasswordArgon2Parallelism int
+	// PasswordMaxConcurrentHashes caps the password hashes computed at once,
+	// each of which takes PasswordArgon2MemoryKiB
+	PasswordMaxConcurrentHashes int
+	PasswordMinLength           int
+	PasswordBreachedList        string
+
+	MediaDir             string
+	DeletedUserChirps    string
+	WebhookAllowInsecure bool
+	// MediaOrphanTTL is how long uploads not attached to a chirp are kept
+	MediaOrphanTTL time.Duration
+	// WebhookRetention is how long finished webhook deliveries, inbound
+	// and outbound, are kept
+	WebhookRetention time.Duration
+
+	MaxChirpLength int
+	BadWords       []string
+}
+
+// Default returns the configuration used for settings that aren't set.
+func Default() Config {
+	return Config{
+		Port:            8080,
+		DBPath:          "database.json",
+		FileRoot:        ".",
+		ShutdownTimeout: 30 * time.Second,
+
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    64 << 10,
+		MaxBodyBytes:      8 << 20,
+		MaxJSONBodyBytes:  64 << 10,
+
+		LogFormat: "text",
+		LogLevel:  "info",
+
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: 60 * 24 * time.Hour,
+
+		MailFrom: "Chirpy <no-reply@chirpy.local>",
+		// Outside the working directory, which FILE_ROOT serves by default
+		MailDir: filepath.Join(os.TempDir(), "chirpy-mail"),
+
+		PasswordArgon2MemoryKiB:     64 * 1024,
+		PasswordArgon2Iterations:    3,
+		PasswordArgon2Parallelism:   2,
+		PasswordMaxConcurrentHashes: 4,
+		PasswordMinLength:           8,
+
+		MediaDir:          "media",
+		MediaOrphanTTL:    24 * time.Hour,
+		DeletedUserChirps: "delete",
+		WebhookRetention:  30 * 24 * time.Hour,
+
+		MaxChirpLength: 140,
+		BadWords:       []string{"kerfuffle", "sharbert", "fornax"},
+	}
+}
+
+// setting describes one configuration value. Its name is the key in the
+// config file; the environment variable is the name in upper case and the
+// flag the name with dashes.
+type setting struct {
+	name   string
+	usage  string
+	secret bool
+	value  value
+}
+
+func (s setting) env() string {
+	return strings.ToUpper(s.name)
+}
+
+func (s setting) flag() string {
+	return strings.ReplaceAll(s.name, "_", "-")
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{name: "port", usage: "port to listen on", value: intValue{&c.Port}},
+		{name: "db_path", usage: "path of the JSON database", value: stringValue{&c.DBPath}},
+		{name: "file_root", usage: "directory served under /app/", value: stringValue{&c.FileRoot}},
+		{name: "public_url", usage: "base URL used in links in emails", value: stringValue{&c.PublicURL}},
+		{name: "shutdown_timeout", usage: "how long to wait for requests and background jobs when stopping", value: durationValue{&c.ShutdownTimeout}},
+		{name: "read_header_timeout", usage: "how long clients have to send request headers", value: durationValue{&c.ReadHeaderTimeout}},
+		{name: "read_timeout", usage: "how long clients have to send a whole request", value: durationValue{&c.ReadTimeout}},
+		{name: "write_timeout", usage: "how long responses may take, except streams", value: durationValue{&c.WriteTimeout}},
+		{name: "idle_timeout", usage: "how long idle keep-alive connections stay open", value: durationValue{&c.IdleTimeout}},
+		{name: "max_header_bytes", usage: "largest request headers accepted", value: intValue{&c.MaxHeaderBytes}},
+		{name: "max_body_bytes", usage: "largest request body accepted by any route", value: intValue{&c.MaxBodyBytes}},
+		{name: "max_json_body_bytes", usage: "largest JSON request body accepted", value: intValue{&c.MaxJSONBodyBytes}},
+		{name: "log_format", usage: `log format, "text" or "json"`, value: stringValue{&c.LogFormat}},
+		{name: "log_level", usage: `lowest level logged: "debug", "info", "warn" or "error"`, value: stringValue{&c.LogLevel}},
+		{name: "metrics_public", usage: "serve /metrics without the admin API key", value: boolValue{&c.MetricsPublic}},
+
+		{name: "jwt_secret", usage: "HS256 secret for access tokens", secret: true, value: stringValue{&c.JWTSecret}},
+		{name: "jwt_keys_dir", usage: "directory with keys.json and PEM keys for RS256/EdDSA signing", value: stringValue{&c.JWTKeysDir}},
+		{name: "access_token_ttl", usage: "lifetime of access tokens", value: durationValue{&c.AccessTokenTTL}},
+		{name: "refresh_token_ttl", usage: "lifetime of refresh tokens that aren't rotated", value: durationValue{&c.RefreshTokenTTL}},
+
+		{name: "admin_api_key", usage: "API key for admin endpoints", secret: true, value: stringValue{&c.AdminAPIKey}},
+		{name: "polka_api_key", usage: "legacy Polka webhook key", secret: true, value: stringValue{&c.PolkaAPIKey}},
+		{name: "polka_webhook_secrets", usage: "comma-separated Polka webhook signing secrets", secret: true, value: listValue{&c.PolkaWebhookSecrets}},
+		{name: "totp_encryption_key", usage: "base64-encoded 32-byte key that encrypts TOTP secrets", secret: true, value: stringValue{&c.TOTPEncryptionKey}},
+
+		{name: "smtp_addr", usage: "SMTP server for outgoing mail", value: stringValue{&c.SMTPAddr}},
+		{name: "smtp_username", usage: "SMTP username", value: stringValue{&c.SMTPUsername}},
+		{name: "smtp_password", usage: "SMTP password", secret: true, value: stringValue{&c.SMTPPassword}},
+		{name: "mail_from", usage: "sender of outgoing mail", value: stringValue{&c.MailFrom}},
+		{name: "mail_dir", usage: "directory mail is written to without an SMTP server", value: stringValue{&c.MailDir}},
+
+		{name: "password_argon2_memory_kib", usage: "argon2id memory cost in KiB", value: intValue{&c.PasswordArgon2MemoryKiB}},
+		{name: "password_argon2_iterations", usage: "argon2id iterations", value: intValue{&c.PasswordArgon2Iterations}},
+		{name: "password_argon2_parallelism", usage: "argon2id parallelism", value: intValue{&c.PasswordArgon2Parallelism}},
+		{name: "password_max_concurrent_hashes", usage: "most password hashes computed at once", value: intValue{&c.PasswordMaxConcurrentHashes}},
+		{name: "password_min_length", usage: "minimum password length", value: intValue{&c.PasswordMinLength}},
+		{name: "password_breached_list", usage: "file of breached passwords", value: stringValue{&c.PasswordBreachedList}},
+
+		{name: "media_dir", usage: "directory uploaded images are stored in", value: stringValue{&c.MediaDir}},
+		{name: "media_orphan_ttl", usage: "how long uploads not attached to a chirp are kept", value: durationValue{&c.MediaOrphanTTL}},
+		{name: "deleted_user_chirps", usage: `"delete" or "anonymize" the chirps of deleted accounts`, value: stringValue{&c.DeletedUserChirps}},
+		{name: "webhook_allow_insecure", usage: "allow webhook endpoints on plain HTTP and private addresses", value: boolValue{&c.WebhookAllowInsecure}},
+		{name: "webhook_retention", usage: "how long finished webhook deliveries are kept", value: durationValue{&c.WebhookRetention}},
+
+		{name: "max_chirp_length", usage: "maximum length of a chirp in bytes", value: intValue{&c.MaxChirpLength}},
+		{name: "bad_words", usage: "comma-separated words censored in chirps", value: listValue{&c.BadWords}},
+	}
+}
+
+// Load builds the configuration from the defaults, the config file named by
+// the -config flag or CHIRPY_CONFIG, getenv and the flags in args, and
+// validates it.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	c := Default()
+	settings := c.settings()
+
+	// Flags are parsed first to find the config file, but applied last
+	fs := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	configPath := fs.String("config", getenv(FileEnv), "path of a JSON config file")
+	flags := map[string]string{}
+	for _, s := range settings {
+		fs.Var(&flagValue{setting: s, flags: flags}, s.flag(), s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if *configPath != "" {
+		if err := c.loadFile(*configPath, settings); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env()); v != "" {
+			if err := s.value.Set(v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", s.env(), err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := flags[s.name]; ok {
+			// Already checked while parsing
+			s.value.Set(v)
+		}
+	}
+
+	if c.PublicURL == "" {
+		c.PublicURL = "http://localhost:" + strconv.Itoa(c.Port)
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string, settings []setting) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	values := map[string]json.RawMessage{}
+	if err := json.NewDecoder(io.LimitReader(f, 1<<20)).Decode(&values); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	known := map[string]setting{}
+	for _, s := range settings {
+		known[s.name] = s
+	}
+	for name, raw := range values {
+		s, ok := known[name]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+		if err := s.value.setJSON(raw); err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, name, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, name, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s (%s): %s", name, strings.ToUpper(name), fmt.Sprintf(format, args...)))
+		}
+	}
+
+	check(c.Port > 0 && c.Port < 1<<16, "port", "must be between 1 and 65535")
+	check(c.DBPath != "", "db_path", "must be set")
+	check(c.FileRoot != "", "file_root", "must be set")
+	u, err := url.Parse(c.PublicURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "public_url", "must be an http or https URL")
+	check(c.ShutdownTimeout > 0 && c.ShutdownTimeout <= 10*time.Minute, "shutdown_timeout", "must be between 1s and 10m")
+	check(c.ReadHeaderTimeout > 0, "read_header_timeout", "must be positive")
+	check(c.ReadTimeout >= c.ReadHeaderTimeout, "read_timeout", "must be at least read_header_timeout")
+	check(c.WriteTimeout > 0, "write_timeout", "must be positive")
+	check(c.IdleTimeout > 0, "idle_timeout", "must be positive")
+	check(c.MaxHeaderBytes >= 4<<10, "max_header_bytes", "must be at least 4096")
+	check(c.MaxJSONBodyBytes >= 1<<10, "max_json_body_bytes", "must be at least 1024")
+	check(c.MaxBodyBytes >= c.MaxJSONBodyBytes, "max_body_bytes", "must be at least max_json_body_bytes")
+	check(c.LogFormat == "text" || c.LogFormat == "json", "log_format", `must be "text" or "json"`)
+	check(slices.Contains([]string{"debug", "info", "warn", "error"}, c.LogLevel), "log_level", `must be "debug", "info", "warn" or "error"`)
+
+	check(c.JWTSecret != "" || c.JWTKeysDir != "", "jwt_secret", "must be set unless jwt_keys_dir is")
+	check(c.AccessTokenTTL > 0 && c.AccessTokenTTL <= 24*time.Hour, "access_token_ttl", "must be between 1s and 24h")
+	check(c.RefreshTokenTTL > c.AccessTokenTTL, "refresh_token_ttl", "must be longer than access_token_ttl")
+
+	if c.TOTPEncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(c.TOTPEncryptionKey)
+		check(err == nil && len(key) == 32, "totp_encryption_key", "must be 32 bytes, base64-encoded")
+	}
+
+	check(c.MailFrom != "", "mail_from", "must be set")
+	check(c.MailDir != "", "mail_dir", "must be set")
+	// Mail holds password reset links, so it must never be served
+	check(c.MailDir == "" || c.FileRoot == "" || !within(c.MailDir, c.FileRoot), "mail_dir", "must be outside file_root, which is served under /app")
+
+	check(c.PasswordArgon2MemoryKiB > 0 && c.PasswordArgon2MemoryKiB <= 1<<22, "password_argon2_memory_kib", "must be between 1 and 4194304")
+	check(c.PasswordArgon2Iterations > 0 && c.PasswordArgon2Iterations <= 100, "password_argon2_iterations", "must be between 1 and 100")
+	check(c.PasswordArgon2Parallelism > 0 && c.PasswordArgon2Parallelism <= 255, "password_argon2_parallelism", "must be between 1 and 255")
+	check(c.PasswordMaxConcurrentHashes > 0, "password_max_concurrent_hashes", "must be positive")
+	check(c.PasswordMinLength > 0 && c.PasswordMinLength <= 1024, "password_min_length", "must be between 1 and 1024")
+
+	check(c.MediaDir != "", "media_dir", "must be set")
+	check(c.MediaOrphanTTL >= time.Hour, "media_orphan_ttl", "must be at least 1h")
+	check(c.DeletedUserChirps == "delete" || c.DeletedUserChirps == "anonymize", "deleted_user_chirps", `must be "delete" or "anonymize"`)
+	check(c.WebhookRetention >= time.Hour, "webhook_retention", "must be at least 1h")
+
+	check(c.MaxChirpLength > 0, "max_chirp_length", "must be positive")
+	for _, word := range c.BadWords {
+		check(word != "" && !strings.Contains(word, " "), "bad_words", "%q must be a single word", word)
+	}
+
+	return errors.Join(errs...)
+}
+
+// within reports whether path is root or inside it.
+func within(path, root string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return true
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Redacted returns the settings by name, with secrets that are set replaced
+// by "REDACTED".
+func (c *Config) Redacted() map[string]interface{} {
+	redacted := map[string]interface{}{}
+	for _, s := range c.settings() {
+		v := s.value.get()
+		if s.secret && s.value.String() != "" {
+			v = "REDACTED"
+		}
+		redacted[s.name] = v
+	}
+	return redacted
+}
+
+// value is a setting's storage. Set parses environment variables and flags;
+// setJSON parses values from the config file.
+type value interface {
+	flag.Value
+	setJSON(raw json.RawMessage) error
+	get() interface{}
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) String() string                    { return *v.p }
+func (v stringValue) Set(s string) error                { *v.p = s; return nil }
+func (v stringValue) setJSON(raw json.RawMessage) error { return json.Unmarshal(raw, v.p) }
+func (v stringValue) get() interface{}                  { return *v.p }
+
+type intValue struct{ p *int }
+
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return errors.New("must be an integer")
+	}
+	*v.p = n
+	return nil
+}
+func (v intValue) setJSON(raw json.RawMessage) error { return json.Unmarshal(raw, v.p) }
+func (v intValue) get() interface{}                  { return *v.p }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) String() string { return strconv.FormatBool(*v.p) }
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return errors.New("must be true or false")
+	}
+	*v.p = b
+	return nil
+}
+func (v boolValue) IsBoolFlag() bool                  { return true }
+func (v boolValue) setJSON(raw json.RawMessage) error { return json.Unmarshal(raw, v.p) }
+func (v boolValue) get() interface{}                  { return *v.p }
+
+// durationValue is written like "90s" or "1h30m" everywhere.
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) String() string { return v.p.String() }
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.New(`must be a duration such as "90s" or "1h"`)
+	}
+	*v.p = d
+	return nil
+}
+func (v durationValue) setJSON(raw json.RawMessage) error {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return errors.New(`must be a duration string such as "90s" or "1h"`)
+	}
+	return v.Set(s)
+}
+func (v durationValue) get() interface{} { return v.p.String() }
+
+// listValue is comma-separated in the environment and flags, and an array
+// in the config file.
+type listValue struct{ p *[]string }
+
+func (v listValue) String() string { return strings.Join(*v.p, ",") }
+func (v listValue) Set(s string) error {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*v.p = list
+	return nil
+}
+func (v listValue) setJSON(raw json.RawMessage) error {
+	list := []string{}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return err
+	}
+	*v.p = list
+	return nil
+}
+func (v listValue) get() interface{} {
+	if *v.p == nil {
+		return []string{}
+	}
+	return *v.p
+}
+
+// flagValue records a flag so it can be applied after the file and the
+// environment, checking that it parses straight away.
+type flagValue struct {
+	setting setting
+	flags   map[string]string
+}
+
+func (f *flagValue) String() string {
+	if f.setting.value == nil {
+		return ""
+	}
+	return f.setting.value.String()
+}
+
+func (f *flagValue) Set(s string) error {
+	if err := f.check(s); err != nil {
+		return err
+	}
+	f.flags[f.setting.name] = s
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	b, ok := f.setting.value.(boolValue)
+	return ok && b.IsBoolFlag()
+}
+
+// check parses s into a scratch value of the same kind.
+func (f *flagValue) check(s string) error {
+	scratch := Default()
+	for _, other := range scratch.settings() {
+		if other.name == f.setting.name {
+			return other.value.Set(s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a getenv backed by vars.
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chirpy.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfigFile(t, `{"port": 9000, "log_level": "warn", "bad_words": ["file"], "jwt_secret": "from-file"}`)
+
+	tests := []struct {
+		name      string
+		args      []string
+		vars      map[string]string
+		port      int
+		logLevel  string
+		badWords  string
+		jwtSecret string
+	}{
+		{
+			name:     "defaults",
+			vars:     map[string]string{"JWT_SECRET": "s"},
+			port:     8080,
+			logLevel: "info",
+			badWords: "kerfuffle,sharbert,fornax",
+		},
+		{
+			name:      "file over defaults",
+			vars:      map[string]string{FileEnv: file},
+			port:      9000,
+			logLevel:  "warn",
+			badWords:  "file",
+			jwtSecret: "from-file",
+		},
+		{
+			name:      "environment over file",
+			vars:      map[string]string{FileEnv: file, "PORT": "9001", "BAD_WORDS": "env, words"},
+			port:      9001,
+			logLevel:  "warn",
+			badWords:  "env,words",
+			jwtSecret: "from-file",
+		},
+		{
+			name:      "flags over environment",
+			args:      []string{"-port", "9002", "-log-level=debug"},
+			vars:      map[string]string{FileEnv: file, "PORT": "9001", "LOG_LEVEL": "error"},
+			port:      9002,
+			logLevel:  "debug",
+			badWords:  "file",
+			jwtSecret: "from-file",
+		},
+		{
+			name:      "config flag over CHIRPY_CONFIG",
+			args:      []string{"-config", file},
+			vars:      map[string]string{FileEnv: "/does/not/exist.json"},
+			port:      9000,
+			logLevel:  "warn",
+			badWords:  "file",
+			jwtSecret: "from-file",
+		},
+		{
+			name:      "empty environment variables are ignored",
+			vars:      map[string]string{FileEnv: file, "PORT": "", "JWT_SECRET": ""},
+			port:      9000,
+			logLevel:  "warn",
+			badWords:  "file",
+			jwtSecret: "from-file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Load(tt.args, env(tt.vars))
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if c.Port != tt.port || c.LogLevel != tt.logLevel || strings.Join(c.BadWords, ",") != tt.badWords {
+				t.Errorf("port %d, log_level %s, bad_words %v; want %d, %s, %s", c.Port, c.LogLevel, c.BadWords, tt.port, tt.logLevel, tt.badWords)
+			}
+			if tt.jwtSecret != "" && c.JWTSecret != tt.jwtSecret {
+				t.Errorf("jwt_secret = %q, want %q", c.JWTSecret, tt.jwtSecret)
+			}
+		})
+	}
+}
+
+func TestLoadDerivesPublicURL(t *testing.T) {
+	c, err := Load([]string{"-port", "9003"}, env(map[string]string{"JWT_SECRET": "s"}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.PublicURL != "http://localhost:9003" {
+		t.Errorf("PublicURL = %q", c.PublicURL)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		vars map[string]string
+		file string
+		want string
+	}{
+		{"missing JWT secret", nil, nil, "", "jwt_secret (JWT_SECRET): must be set unless jwt_keys_dir is"},
+		{"bad environment value", nil, map[string]string{"JWT_SECRET": "s", "PORT": "eighty"}, "", "PORT: must be an integer"},
+		{"bad duration", nil, map[string]string{"JWT_SECRET": "s", "ACCESS_TOKEN_TTL": "1 hour"}, "", `ACCESS_TOKEN_TTL: must be a duration such as "90s" or "1h"`},
+		{"bad flag", []string{"-webhook-allow-insecure=maybe"}, map[string]string{"JWT_SECRET": "s"}, "", "must be true or false"},
+		{"unknown flag", []string{"-colour", "blue"}, map[string]string{"JWT_SECRET": "s"}, "", "flag provided but not defined: -colour"},
+		{"extra argument", []string{"serve"}, map[string]string{"JWT_SECRET": "s"}, "", `unexpected argument "serve"`},
+		{"unknown file setting", nil, map[string]string{"JWT_SECRET": "s"}, `{"prot": 80}`, `unknown setting "prot"`},
+		{"wrong file type", nil, map[string]string{"JWT_SECRET": "s"}, `{"port": "80"}`, "port:"},
+		{"file duration as number", nil, map[string]string{"JWT_SECRET": "s"}, `{"access_token_ttl": 3600}`, "access_token_ttl: must be a duration string"},
+		{"malformed file", nil, map[string]string{"JWT_SECRET": "s"}, `{"port": `, "config file"},
+		{"missing file", []string{"-config", "/does/not/exist.json"}, map[string]string{"JWT_SECRET": "s"}, "", "config file:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{}
+			for k, v := range tt.vars {
+				vars[k] = v
+			}
+			if tt.file != "" {
+				vars[FileEnv] = writeConfigFile(t, tt.file)
+			}
+			_, err := Load(tt.args, env(vars))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load err = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		c := Default()
+		c.JWTSecret = "secret"
+		c.PublicURL = "http://localhost:8080"
+		return c
+	}
+	if c := valid(); c.Validate() != nil {
+		t.Fatalf("valid config: %v", c.Validate())
+	}
+
+	tests := []struct {
+		setting string
+		change  func(*Config)
+	}{
+		{"port", func(c *Config) { c.Port = 0 }},
+		{"port", func(c *Config) { c.Port = 70000 }},
+		{"db_path", func(c *Config) { c.DBPath = "" }},
+		{"file_root", func(c *Config) { c.FileRoot = "" }},
+		{"public_url", func(c *Config) { c.PublicURL = "localhost:8080" }},
+		{"public_url", func(c *Config) { c.PublicURL = "ftp://chirpy.example" }},
+		{"shutdown_timeout", func(c *Config) { c.ShutdownTimeout = 0 }},
+		{"shutdown_timeout", func(c *Config) { c.ShutdownTimeout = time.Hour }},
+		{"read_header_timeout", func(c *Config) { c.ReadHeaderTimeout = 0 }},
+		{"read_timeout", func(c *Config) { c.ReadTimeout = time.Second }},
+		{"write_timeout", func(c *Config) { c.WriteTimeout = 0 }},
+		{"idle_timeout", func(c *Config) { c.IdleTimeout = -time.Second }},
+		{"max_header_bytes", func(c *Config) { c.MaxHeaderBytes = 100 }},
+		{"max_json_body_bytes", func(c *Config) { c.MaxJSONBodyBytes = 100 }},
+		{"max_body_bytes", func(c *Config) { c.MaxBodyBytes = 1 << 10 }},
+		{"log_format", func(c *Config) { c.LogFormat = "xml" }},
+		{"log_level", func(c *Config) { c.LogLevel = "verbose" }},
+		{"jwt_secret", func(c *Config) { c.JWTSecret = "" }},
+		{"access_token_ttl", func(c *Config) { c.AccessTokenTTL = 0 }},
+		{"access_token_ttl", func(c *Config) { c.AccessTokenTTL = 48 * time.Hour; c.RefreshTokenTTL = 96 * time.Hour }},
+		{"refresh_token_ttl", func(c *Config) { c.RefreshTokenTTL = c.AccessTokenTTL }},
+		{"totp_encryption_key", func(c *Config) { c.TOTPEncryptionKey = "c2hvcnQ=" }},
+		{"totp_encryption_key", func(c *Config) { c.TOTPEncryptionKey = "not base64!" }},
+		{"mail_from", func(c *Config) { c.MailFrom = "" }},
+		{"mail_dir", func(c *Config) { c.MailDir = "" }},
+		{"mail_dir", func(c *Config) { c.MailDir = "mail" }},
+		{"mail_dir", func(c *Config) { c.FileRoot = "/srv/chirpy"; c.MailDir = "/srv/chirpy/app/mail" }},
+		{"password_argon2_memory_kib", func(c *Config) { c.PasswordArgon2MemoryKiB = 0 }},
+		{"password_argon2_iterations", func(c *Config) { c.PasswordArgon2Iterations = 101 }},
+		{"password_argon2_parallelism", func(c *Config) { c.PasswordArgon2Parallelism = 256 }},
+		{"password_max_concurrent_hashes", func(c *Config) { c.PasswordMaxConcurrentHashes = 0 }},
+		{"password_min_length", func(c *Config) { c.PasswordMinLength = 0 }},
+		{"media_dir", func(c *Config) { c.MediaDir = "" }},
+		{"media_orphan_ttl", func(c *Config) { c.MediaOrphanTTL = time.Minute }},
+		{"deleted_user_chirps", func(c *Config) { c.DeletedUserChirps = "keep" }},
+		{"webhook_retention", func(c *Config) { c.WebhookRetention = time.Minute }},
+		{"max_chirp_length", func(c *Config) { c.MaxChirpLength = 0 }},
+		{"bad_words", func(c *Config) { c.BadWords = []string{"two words"} }},
+		{"bad_words", func(c *Config) { c.BadWords = []string{""} }},
+	}
+
+	for _, tt := range tests {
+		c := valid()
+		tt.change(&c)
+		err := c.Validate()
+		want := tt.setting + " (" + strings.ToUpper(tt.setting) + "): "
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%s: err = %v, want one starting with %q", tt.setting, err, want)
+		}
+	}
+}
+
+func TestValidateReportsEverySetting(t *testing.T) {
+	c := Default()
+	c.PublicURL = "http://localhost:8080"
+	c.Port = 0
+	c.LogFormat = "xml"
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Validate succeeded")
+	}
+	for _, want := range []string{"port (PORT)", "log_format (LOG_FORMAT)", "jwt_secret (JWT_SECRET)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, missing %s", err, want)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := Default()
+	c.JWTSecret = "jwt-secret"
+	c.AdminAPIKey = "admin-key"
+	c.PolkaWebhookSecrets = []string{"old", "new"}
+	c.SMTPAddr = "smtp.example.com:587"
+
+	redacted := c.Redacted()
+	for _, name := range []string{"jwt_secret", "admin_api_key", "polka_webhook_secrets"} {
+		if redacted[name] != "REDACTED" {
+			t.Errorf("%s = %v, want REDACTED", name, redacted[name])
+		}
+	}
+	// Unset secrets show that they are unset
+	if redacted["polka_api_key"] != "" {
+		t.Errorf("polka_api_key = %v, want empty", redacted["polka_api_key"])
+	}
+	if redacted["smtp_addr"] != "smtp.example.com:587" || redacted["port"] != 8080 || redacted["access_token_ttl"] != "1h0m0s" {
+		t.Errorf("plain settings = %v, %v, %v", redacted["smtp_addr"], redacted["port"], redacted["access_token_ttl"])
+	}
+}
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		path, root string
+		want       bool
+	}{
+		{"/srv/chirpy", "/srv/chirpy", true},
+		{"/srv/chirpy/mail", "/srv/chirpy", true},
+		{"/srv/chirpy/../chirpy/mail", "/srv/chirpy", true},
+		{"/srv/chirpy-mail", "/srv/chirpy", false},
+		{"/srv/mail", "/srv/chirpy", false},
+		{"/srv", "/srv/chirpy", false},
+		{"..mail", ".", true},
+	}
+	for _, tt := range tests {
+		if got := within(tt.path, tt.root); got != tt.want {
+			t.Errorf("within(%q, %q) = %v, want %v", tt.path, tt.root, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// UserExport is everything stored about a user, minus secrets such as
+// password and token hashes.
+type UserExport struct {
+	User                 User
+	Chirps               []Chirp
+	Media                []Media
+	Followers            []Follow
+	Following            []Follow
+	Sessions             []Session
+	PersonalAccessTokens []PersonalAccessToken
+	EmailTokens          []EmailToken
+	WebhookEndpoints     []WebhookEndpoint
+	Notifications        []Notification
+	// WebhookEvents and LoginThrottles aren't keyed by user, so ExportUser
+	// leaves them for the caller to fill in
+	WebhookEvents  []WebhookEvent
+	LoginThrottles map[string]LoginThrottle
+}
+
+// DeleteUser deletes the user along with their sessions, tokens, follows,
+// uploads, webhook endpoints and notifications, including those they caused
+// for others, in a single write. With anonymizeChirps their
+// chirps are kept without an author, otherwise they are deleted too. The
+// returned images are the blobs that are no longer referenced.
+func (db *DB) DeleteUser(id int, anonymizeChirps bool) ([]Image, error) {
+	var orphaned []Image
+	err := db.update(func(dbStructure *DBStructure) error {
+		var err error
+		orphaned, err = deleteUser(*dbStructure, id, anonymizeChirps)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return orphaned, nil
+}
+
+// deleteUser removes the user and everything tied to them from dbStructure,
+// returning the images that are no longer referenced.
+func deleteUser(dbStructure DBStructure, id int, anonymizeChirps bool) ([]Image, error) {
+	user, ok := dbStructure.Users[id]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	delete(dbStructure.Users, id)
+
+	orphaned := []Image{}
+	if user.Avatar != nil {
+		orphaned = append(orphaned, *user.Avatar)
+	}
+
+	deletedChirps := map[int]bool{}
+	for chirpID, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID != id {
+			continue
+		}
+		if anonymizeChirps {
+			chirp.AuthorID = 0
+			dbStructure.Chirps[chirpID] = chirp
+			continue
+		}
+		deletedChirps[chirpID] = true
+		delete(dbStructure.Chirps, chirpID)
+	}
+
+	for mediaID, media := range dbStructure.Media {
+		if media.OwnerID != id {
+			continue
+		}
+		// Attachments of anonymized chirps stay with the chirp
+		if media.ChirpID != 0 && !deletedChirps[media.ChirpID] {
+			media.OwnerID = 0
+			dbStructure.Media[mediaID] = media
+			continue
+		}
+		orphaned = append(orphaned, media.Image)
+		delete(dbStructure.Media, mediaID)
+	}
+
+	for tokenID, token := range dbStructure.RefreshTokens {
+		if token.UserID == id {
+			delete(dbStructure.RefreshTokens, tokenID)
+		}
+	}
+	for tokenID, token := range dbStructure.PersonalAccessTokens {
+		if token.UserID == id {
+			delete(dbStructure.PersonalAccessTokens, tokenID)
+		}
+	}
+	for tokenID, token := range dbStructure.EmailTokens {
+		if token.UserID == id {
+			delete(dbStructure.EmailTokens, tokenID)
+		}
+	}
+	for followID, follow := range dbStructure.Follows {
+		if follow.FollowerID == id || follow.FolloweeID == id {
+			delete(dbStructure.Follows, followID)
+		}
+	}
+	for endpointID, endpoint := range dbStructure.WebhookEndpoints {
+		if endpoint.OwnerID == id {
+			deleteWebhookEndpoint(dbStructure, endpointID)
+		}
+	}
+	for notificationID, notification := range dbStructure.Notifications {
+		if notification.UserID == id || notification.ActorID == id {
+			delete(dbStructure.Notifications, notificationID)
+		}
+	}
+
+	return orphaned, nil
+}
+
+// ExportUser collects everything stored about the user from a single read,
+// so the export is consistent.
+func (db *DB) ExportUser(id int) (UserExport, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	user, ok := dbStructure.Users[id]
+	if !ok {
+		return UserExport{}, ErrNotExist
+	}
+	user.Password = ""
+	user.TwoFactor.Secret = ""
+	user.TwoFactor.RecoveryCodes = nil
+
+	export := UserExport{
+		User:                 user,
+		Chirps:               []Chirp{},
+		Media:                []Media{},
+		Followers:            []Follow{},
+		Following:            []Follow{},
+		Sessions:             []Session{},
+		PersonalAccessTokens: []PersonalAccessToken{},
+		EmailTokens:          []EmailToken{},
+		WebhookEndpoints:     []WebhookEndpoint{},
+		Notifications:        []Notification{},
+		WebhookEvents:        []WebhookEvent{},
+		LoginThrottles:       map[string]LoginThrottle{},
+	}
+
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID == id {
+			export.Chirps = append(export.Chirps, chirp)
+		}
+	}
+	for _, media := range dbStructure.Media {
+		if media.OwnerID == id {
+			export.Media = append(export.Media, media)
+		}
+	}
+	for _, follow := range dbStructure.Follows {
+		if follow.FolloweeID == id {
+			export.Followers = append(export.Followers, follow)
+		}
+		if follow.FollowerID == id {
+			export.Following = append(export.Following, follow)
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, token := range dbStructure.RefreshTokens {
+		if token.UserID != id || !token.active(now) {
+			continue
+		}
+		first := dbStructure.RefreshTokens[token.FamilyID]
+		export.Sessions = append(export.Sessions, Session{
+			ID:         token.FamilyID,
+			UserID:     token.UserID,
+			CreatedAt:  first.CreatedAt,
+			LastUsedAt: token.CreatedAt,
+			ExpiresAt:  token.ExpiresAt,
+			ClientInfo: token.ClientInfo,
+		})
+	}
+	for _, token := range dbStructure.PersonalAccessTokens {
+		if token.UserID == id {
+			token.TokenHash = ""
+			export.PersonalAccessTokens = append(export.PersonalAccessTokens, token)
+		}
+	}
+	for _, token := range dbStructure.EmailTokens {
+		if token.UserID == id {
+			token.TokenHash = ""
+			export.EmailTokens = append(export.EmailTokens, token)
+		}
+	}
+	for _, endpoint := range dbStructure.WebhookEndpoints {
+		if endpoint.OwnerID == id {
+			endpoint.Secret = ""
+			export.WebhookEndpoints = append(export.WebhookEndpoints, endpoint)
+		}
+	}
+
+	for _, notification := range dbStructure.Notifications {
+		if notification.UserID == id {
+			export.Notifications = append(export.Notifications, notification)
+		}
+	}
+
+	sort.Slice(export.Chirps, func(i, j int) bool { return export.Chirps[i].ID < export.Chirps[j].ID })
+	sort.Slice(export.Media, func(i, j int) bool { return export.Media[i].ID < export.Media[j].ID })
+	sort.Slice(export.Followers, func(i, j int) bool { return export.Followers[i].ID < export.Followers[j].ID })
+	sort.Slice(export.Following, func(i, j int) bool { return export.Following[i].ID < export.Following[j].ID })
+	sort.Slice(export.Sessions, func(i, j int) bool { return export.Sessions[i].ID < export.Sessions[j].ID })
+	sort.Slice(export.WebhookEndpoints, func(i, j int) bool { return export.WebhookEndpoints[i].ID < export.WebhookEndpoints[j].ID })
+	sort.Slice(export.Notifications, func(i, j int) bool { return export.Notifications[i].ID < export.Notifications[j].ID })
+
+	return export, nil
+}
@@ -4,33 +4,89 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 )
 
-var ErrNotExist = errors.New("resource does not exist")
+var (
+	ErrNotExist    = errors.New("resource does not exist")
+	ErrEmailTaken  = errors.New("email belongs to another user")
+	ErrHandleTaken = errors.New("handle belongs to another user")
+	ErrClosed      = errors.New("database is closed")
+	ErrLocked      = errors.New("database is in use by another process")
+
+	// errUnchanged tells update that fn made no changes worth writing
+	errUnchanged = errors.New("nothing to write")
+)
+
+// lockRetryInterval is how often Lock tries again while another process
+// holds the database
+const lockRetryInterval = 50 * time.Millisecond
 
 type DB struct {
-	path string
-	mu   *sync.RWMutex
+	path   string
+	mu     *sync.RWMutex
+	closed bool
+	// lockFile holds the lock taken by Lock until Close
+	lockFile *os.File
+	// observe, if set, is told how long each load and write took
+	observe func(operation string, elapsed time.Duration)
 }
 
 type DBStructure struct {
-	Chirps map[int]Chirp `json:"chirps"`
-	Users  map[int]User  `json:"users"`
+	Chirps               map[int]Chirp               `json:"chirps"`
+	Users                map[int]User                `json:"users"`
+	RefreshTokens        map[int]RefreshToken        `json:"refresh_tokens"`
+	PersonalAccessTokens map[int]PersonalAccessToken `json:"personal_access_tokens"`
+	EmailTokens          map[int]EmailToken          `json:"email_tokens"`
+	LoginThrottles       map[string]LoginThrottle    `json:"login_throttles"`
+	Follows              map[int]Follow              `json:"follows"`
+	Media                map[int]Media               `json:"media"`
+	WebhookDeliveries    map[string]time.Time        `json:"webhook_deliveries"`
+	WebhookEvents        map[int]WebhookEvent        `json:"webhook_events"`
+	WebhookEndpoints     map[int]WebhookEndpoint     `json:"webhook_endpoints"`
+	OutboundDeliveries   map[int]OutboundDelivery    `json:"outbound_deliveries"`
+	Notifications        map[int]Notification        `json:"notifications"`
+	// UsedChallenges are the IDs of spent two-factor login challenges,
+	// kept until the challenge would have expired anyway
+	UsedChallenges map[string]time.Time `json:"used_challenges"`
+	// LastUserID is the highest user ID ever handed out, so IDs of deleted
+	// users are never reused and their old tokens can't match a new account
+	LastUserID int `json:"last_user_id"`
 }
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorID int    `json:"author_id"`
+	ID        int       `json:"id"`
+	Body      string    `json:"body"`
+	AuthorID  int       `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type User struct {
-	ID           int    `json:"id"`
-	Email        string `json:"email"`
-	Password     string `json:"password"`
-	RefreshToken string `json:"refresh_token"`
-	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	ID       int    `json:"id"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// Subscription is the user's Chirpy Red subscription, if they ever had
+	// one. Use IsChirpyRed to check whether it is active.
+	Subscription *Subscription `json:"subscription,omitempty"`
+	// LegacyChirpyRed is read from databases written before subscriptions
+	// were tracked, and migrated to an open-ended subscription on load.
+	LegacyChirpyRed bool `json:"is_chirpy_red,omitempty"`
+	// TokensValidAfter invalidates every access token issued before it.
+	TokensValidAfter time.Time `json:"tokens_valid_after"`
+	Suspended        bool      `json:"suspended"`
+	TwoFactor        TwoFactor `json:"two_factor"`
+	EmailVerified    bool      `json:"email_verified"`
+	DisplayName      string    `json:"display_name"`
+	Bio              string    `json:"bio"`
+	// Handle is unique, compared case-insensitively, and may be empty
+	Handle    string    `json:"handle,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Avatar    *Image    `json:"avatar,omitempty"`
+	// MutedNotifications are the notification types the user turned off
+	MutedNotifications []string `json:"muted_notifications,omitempty"`
 }
 
 func NewDB(path string) (*DB, error) {
@@ -42,47 +98,104 @@ func NewDB(path string) (*DB, error) {
 	return db, err
 }
 
-func (db *DB) CreateChirp(body string, author_id int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
+// Lock takes an exclusive lock on the database for this process, waiting up
+// to timeout for another process to release it, and reports ErrLocked if it
+// doesn't. Close releases it. The lock is on a file next to the database,
+// since writes replace the database file itself.
+func (db *DB) Lock(timeout time.Duration) error {
+	f, err := os.OpenFile(db.path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
 	if err != nil {
-		return Chirp{}, err
+		return err
 	}
 
-	id := len(dbStructure.Chirps) + 1
-	chirp := Chirp{
-		ID:       id,
-		Body:     body,
-		AuthorID: author_id,
+	deadline := time.Now().Add(timeout)
+	for {
+		err = tryLock(f)
+		if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(lockRetryInterval)
 	}
-	dbStructure.Chirps[id] = chirp
-
-	err = db.writeDB(dbStructure)
 	if err != nil {
-		return Chirp{}, err
+		f.Close()
+		return err
 	}
 
-	return chirp, nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.lockFile = f
+	return nil
 }
 
-func (db *DB) DeleteChirp(id int) error {
-	dbStructure, err := db.loadDB()
+// ObserveOperations calls observe with the duration of every load ("load")
+// and write ("write") of the file. It must be called before the database is
+// used.
+func (db *DB) ObserveOperations(observe func(operation string, elapsed time.Duration)) {
+	db.observe = observe
+}
+
+// CreateChirp creates a chirp with the given media attached. The media must
+// belong to the author and not be attached to another chirp.
+func (db *DB) CreateChirp(body string, author_id int, mediaIDs []int) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbStructure *DBStructure) error {
+		// Deleted chirps leave gaps, so the count can't be used as the next ID
+		id := nextID(dbStructure.Chirps)
+		for _, mediaID := range mediaIDs {
+			media, ok := dbStructure.Media[mediaID]
+			if !ok || media.OwnerID != author_id || media.ChirpID != 0 {
+				return ErrMediaUnavailable
+			}
+			media.ChirpID = id
+			dbStructure.Media[mediaID] = media
+		}
+
+		chirp = Chirp{
+			ID:        id,
+			Body:      body,
+			AuthorID:  author_id,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.Chirps[id] = chirp
+		return nil
+	})
 	if err != nil {
-		return err
+		return Chirp{}, err
 	}
 
-	_, ok := dbStructure.Chirps[id]
-	if !ok {
-		return ErrNotExist
-	}
+	return chirp, nil
+}
+
+// DeleteChirp deletes the chirp, its media and the notifications about it,
+// returning the media so their blobs can be deleted too.
+func (db *DB) DeleteChirp(id int) ([]Media, error) {
+	deleted := []Media{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		_, ok := dbStructure.Chirps[id]
+		if !ok {
+			return ErrNotExist
+		}
 
-	delete(dbStructure.Chirps, id)
+		delete(dbStructure.Chirps, id)
 
-	err = db.writeDB(dbStructure)
+		for mediaID, media := range dbStructure.Media {
+			if media.ChirpID == id {
+				deleted = append(deleted, media)
+				delete(dbStructure.Media, mediaID)
+			}
+		}
+		for notificationID, notification := range dbStructure.Notifications {
+			if notification.ChirpID == id {
+				delete(dbStructure.Notifications, notificationID)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return deleted, nil
 }
 
 func (db *DB) GetChirps() ([]Chirp, error) {
@@ -129,22 +242,28 @@ func (db *DB) GetChirp(id int) (Chirp, error) {
 	return chirp, nil
 }
 
-func (db *DB) CreateUser(email, password string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	id := len(dbStructure.Users) + 1
-	user := User{
-		ID:          id,
-		Email:       email,
-		Password:    password,
-		IsChirpyRed: false,
-	}
-	dbStructure.Users[id] = user
+func (db *DB) CreateUser(email, password, handle string) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		if emailTaken(*dbStructure, email, 0) {
+			return ErrEmailTaken
+		}
+		if handle != "" && handleTaken(*dbStructure, handle, 0) {
+			return ErrHandleTaken
+		}
 
-	err = db.writeDB(dbStructure)
+		id := max(nextID(dbStructure.Users), dbStructure.LastUserID+1)
+		dbStructure.LastUserID = id
+		user = User{
+			ID:        id,
+			Email:     email,
+			Password:  password,
+			Handle:    handle,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.Users[id] = user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -152,74 +271,146 @@ func (db *DB) CreateUser(email, password string) (User, error) {
 	return user, nil
 }
 
+// UpdateUser replaces the user's email and password hash. A new email has
+// to be verified again.
 func (db *DB) UpdateUser(id int, email, password string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
+	return db.updateUser(id, func(dbStructure *DBStructure, user *User) error {
+		if emailTaken(*dbStructure, email, id) {
+			return ErrEmailTaken
+		}
 
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return User{}, ErrNotExist
-	}
+		if !strings.EqualFold(user.Email, email) {
+			user.EmailVerified = false
+		}
+		user.Email = email
+		user.Password = password
+		return nil
+	})
+}
+
+// SuspendUser blocks the user from logging in and revokes all their tokens.
+func (db *DB) SuspendUser(id int) error {
+	_, err := db.updateUser(id, func(dbStructure *DBStructure, user *User) error {
+		user.Suspended = true
+		revokeUserTokens(*dbStructure, user, time.Now().UTC())
+		return nil
+	})
+	return err
+}
+
+func (db *DB) VerifyUserEmail(id int) error {
+	_, err := db.updateUser(id, func(dbStructure *DBStructure, user *User) error {
+		user.EmailVerified = true
+		return nil
+	})
+	return err
+}
+
+// UpdateUserProfile sets the profile fields that are not nil.
+func (db *DB) UpdateUserProfile(id int, displayName, bio *string) (User, error) {
+	return db.updateUser(id, func(dbStructure *DBStructure, user *User) error {
+		if displayName != nil {
+			user.DisplayName = *displayName
+		}
+		if bio != nil {
+			user.Bio = *bio
+		}
+		return nil
+	})
+}
 
-	user.Email = email
-	user.Password = password
-	dbStructure.Users[id] = user
+// SetUserHandle sets the user's handle.
+func (db *DB) SetUserHandle(id int, handle string) (User, error) {
+	return db.updateUser(id, func(dbStructure *DBStructure, user *User) error {
+		if handleTaken(*dbStructure, handle, id) {
+			return ErrHandleTaken
+		}
+
+		user.Handle = handle
+		return nil
+	})
+}
 
-	err = db.writeDB(dbStructure)
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	dbStructure, err := db.loadDB()
 	if err != nil {
 		return User{}, err
 	}
 
-	return user, nil
+	for _, user := range dbStructure.Users {
+		if user.Handle != "" && strings.EqualFold(user.Handle, handle) {
+			return user, nil
+		}
+	}
+
+	return User{}, ErrNotExist
 }
 
-func (db *DB) UpgradeUser(id int) error {
+// GetUsersByIDs returns the users with the given IDs, keyed by ID. Unknown
+// IDs are left out.
+func (db *DB) GetUsersByIDs(ids []int) (map[int]User, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return ErrNotExist
+	users := make(map[int]User, len(ids))
+	for _, id := range ids {
+		if user, ok := dbStructure.Users[id]; ok {
+			users[id] = user
+		}
 	}
 
-	user.IsChirpyRed = true
-	dbStructure.Users[id] = user
+	return users, nil
+}
 
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
+// ChangeUserEmail switches the user to an address they have confirmed they
+// own, so it is marked verified.
+func (db *DB) ChangeUserEmail(id int, email string) (User, error) {
+	return db.updateUser(id, func(dbStructure *DBStructure, user *User) error {
+		if emailTaken(*dbStructure, email, id) {
+			return ErrEmailTaken
+		}
 
-	return nil
+		user.Email = email
+		user.EmailVerified = true
+		return nil
+	})
 }
 
-func (db *DB) GetUserByEmail(email string) (User, error) {
+func (db *DB) UpdateUserPassword(id int, password string) error {
+	_, err := db.updateUser(id, func(dbStructure *DBStructure, user *User) error {
+		user.Password = password
+		return nil
+	})
+	return err
+}
+
+// CountChirpsByAuthorSince counts the chirps the author created after since.
+func (db *DB) CountChirpsByAuthorSince(authorID int, since time.Time) (int, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
-		return User{}, err
+		return 0, err
 	}
 
-	for _, user := range dbStructure.Users {
-		if user.Email == email {
-			return user, nil
+	count := 0
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID == authorID && chirp.CreatedAt.After(since) {
+			count++
 		}
 	}
 
-	return User{}, ErrNotExist
+	return count, nil
 }
 
-func (db *DB) GetUserByRefreshToken(refreshToken string) (User, error) {
+func (db *DB) GetUserByEmail(email string) (User, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return User{}, err
 	}
 
 	for _, user := range dbStructure.Users {
-		if user.RefreshToken == refreshToken {
+		if strings.EqualFold(user.Email, email) {
 			return user, nil
 		}
 	}
@@ -242,69 +433,166 @@ func (db *DB) GetUserByID(id int) (User, error) {
 	return User{}, ErrNotExist
 }
 
-func (db *DB) DeleteRefreshToken(id int) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
+func (db *DB) createDB() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStructure := DBStructure{}
+	dbStructure.init()
+	return db.writeFile(dbStructure)
+}
+
+func (db *DB) ensureDB() error {
+	_, err := os.ReadFile(db.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return db.createDB()
 	}
+	return err
+}
 
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return ErrNotExist
+// emailTaken reports whether a user other than exceptID has the email.
+// Emails are compared case-insensitively.
+func emailTaken(dbStructure DBStructure, email string, exceptID int) bool {
+	for _, user := range dbStructure.Users {
+		if user.ID != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
 	}
+	return false
+}
 
-	user.RefreshToken = ""
-	dbStructure.Users[id] = user
+// handleTaken reports whether a user other than exceptID has the handle.
+func handleTaken(dbStructure DBStructure, handle string, exceptID int) bool {
+	for _, user := range dbStructure.Users {
+		if user.ID != exceptID && strings.EqualFold(user.Handle, handle) {
+			return true
+		}
+	}
+	return false
+}
 
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
+// init allocates any tables missing from the file, so databases written by
+// older versions load cleanly.
+func (dbStructure *DBStructure) init() {
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = map[int]Chirp{}
+	}
+	if dbStructure.Users == nil {
+		dbStructure.Users = map[int]User{}
+	}
+	if dbStructure.RefreshTokens == nil {
+		dbStructure.RefreshTokens = map[int]RefreshToken{}
+	}
+	if dbStructure.PersonalAccessTokens == nil {
+		dbStructure.PersonalAccessTokens = map[int]PersonalAccessToken{}
+	}
+	if dbStructure.EmailTokens == nil {
+		dbStructure.EmailTokens = map[int]EmailToken{}
+	}
+	if dbStructure.LoginThrottles == nil {
+		dbStructure.LoginThrottles = map[string]LoginThrottle{}
+	}
+	if dbStructure.Follows == nil {
+		dbStructure.Follows = map[int]Follow{}
+	}
+	if dbStructure.Media == nil {
+		dbStructure.Media = map[int]Media{}
+	}
+	if dbStructure.WebhookDeliveries == nil {
+		dbStructure.WebhookDeliveries = map[string]time.Time{}
+	}
+	if dbStructure.UsedChallenges == nil {
+		dbStructure.UsedChallenges = map[string]time.Time{}
+	}
+	if dbStructure.WebhookEvents == nil {
+		dbStructure.WebhookEvents = map[int]WebhookEvent{}
+	}
+	if dbStructure.WebhookEndpoints == nil {
+		dbStructure.WebhookEndpoints = map[int]WebhookEndpoint{}
 	}
+	if dbStructure.OutboundDeliveries == nil {
+		dbStructure.OutboundDeliveries = map[int]OutboundDelivery{}
+	}
+	if dbStructure.Notifications == nil {
+		dbStructure.Notifications = map[int]Notification{}
+	}
+}
 
-	return nil
+// nextID returns an ID one above the largest key in the table.
+func nextID[T any](table map[int]T) int {
+	id := 0
+	for k := range table {
+		if k > id {
+			id = k
+		}
+	}
+	return id + 1
 }
 
-func (db *DB) UpdateUserRefreshToken(id int, refreshToken string) error {
-	dbStructure, err := db.loadDB()
+func (db *DB) loadDB() (DBStructure, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.readFile()
+}
+
+// update applies fn to the current contents and writes the result. The
+// write lock is held from the read to the write, so checks fn makes still
+// hold when its changes land. Nothing is written if fn fails, and
+// errUnchanged skips the write without failing.
+func (db *DB) update(fn func(dbStructure *DBStructure) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	dbStructure, err := db.readFile()
 	if err != nil {
 		return err
 	}
-
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return ErrNotExist
+	err = fn(&dbStructure)
+	if errors.Is(err, errUnchanged) {
+		return nil
 	}
-
-	user.RefreshToken = refreshToken
-	dbStructure.Users[id] = user
-
-	err = db.writeDB(dbStructure)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return db.writeFile(dbStructure)
 }
 
-func (db *DB) createDB() error {
-	dbStructure := DBStructure{
-		Chirps: map[int]Chirp{},
-		Users:  map[int]User{},
-	}
-	return db.writeDB(dbStructure)
-}
+// updateUser applies fn to the user with the given ID and returns the
+// updated user.
+func (db *DB) updateUser(id int, fn func(dbStructure *DBStructure, user *User) error) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
 
-func (db *DB) ensureDB() error {
-	_, err := os.ReadFile(db.path)
-	if errors.Is(err, os.ErrNotExist) {
-		return db.createDB()
+		err := fn(dbStructure, &user)
+		if err != nil {
+			return err
+		}
+		dbStructure.Users[id] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
 	}
-	return err
+
+	return user, nil
 }
 
-func (db *DB) loadDB() (DBStructure, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+// readFile must be called with db.mu held.
+func (db *DB) readFile() (DBStructure, error) {
+	if db.observe != nil {
+		defer db.observeSince("load", time.Now())
+	}
 
 	dbStructure := DBStructure{}
 	dat, err := os.ReadFile(db.path)
@@ -315,22 +603,59 @@ func (db *DB) loadDB() (DBStructure, error) {
 	if err != nil {
 		return dbStructure, err
 	}
+	dbStructure.init()
+	migrateLegacyChirpyRed(dbStructure)
 
 	return dbStructure, nil
 }
 
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// writeFile replaces the file atomically, so a crash mid-write leaves the
+// previous version rather than a truncated one. It must be called with
+// db.mu held for writing.
+func (db *DB) writeFile(dbStructure DBStructure) error {
+	if db.observe != nil {
+		defer db.observeSince("write", time.Now())
+	}
 
 	dat, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(db.path, dat, 0600)
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(dat)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), db.path)
+}
+
+func (db *DB) observeSince(operation string, start time.Time) {
+	db.observe(operation, time.Since(start))
+}
+
+// Close waits for the write in progress, if any, to finish and releases the
+// lock taken by Lock. Writes after it fail with ErrClosed.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.closed = true
+	if db.lockFile != nil {
+		err := db.lockFile.Close()
+		db.lockFile = nil
+		return err
+	}
 	return nil
 }
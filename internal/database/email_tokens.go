@@ -0,0 +1,90 @@
+package database
+
+import "time"
+
+// Purposes of email tokens.
+const (
+	EmailTokenVerify = "verify_email"
+	EmailTokenReset  = "reset_password"
+	// EmailTokenChangeEmail tokens are sent to the new address; their Email
+	// is the address to switch to.
+	EmailTokenChangeEmail = "change_email"
+)
+
+// EmailToken is a single-use token mailed to a user. Only its SHA-256 hash is
+// stored.
+type EmailToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	Purpose   string     `json:"purpose"`
+	Email     string     `json:"email"`
+	TokenHash string     `json:"token_hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// CreateEmailToken stores a new token for the user. Earlier tokens for the
+// same purpose stop working, so only the latest email counts. Tokens of any
+// user that were used or have expired are deleted too, since they can't be
+// used again.
+func (db *DB) CreateEmailToken(userID int, purpose, email, tokenHash string, expiresAt time.Time) (EmailToken, error) {
+	var token EmailToken
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for id, token := range dbStructure.EmailTokens {
+			replaced := token.UserID == userID && token.Purpose == purpose
+			if replaced || token.UsedAt != nil || now.After(token.ExpiresAt) {
+				delete(dbStructure.EmailTokens, id)
+			}
+		}
+
+		id := nextID(dbStructure.EmailTokens)
+		token = EmailToken{
+			ID:        id,
+			UserID:    userID,
+			Purpose:   purpose,
+			Email:     email,
+			TokenHash: tokenHash,
+			CreatedAt: now,
+			ExpiresAt: expiresAt,
+		}
+		dbStructure.EmailTokens[id] = token
+		return nil
+	})
+	if err != nil {
+		return EmailToken{}, err
+	}
+
+	return token, nil
+}
+
+// UseEmailToken consumes the token matching tokenHash for the given purpose.
+func (db *DB) UseEmailToken(purpose, tokenHash string) (EmailToken, error) {
+	var used EmailToken
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, token := range dbStructure.EmailTokens {
+			if token.TokenHash != tokenHash || token.Purpose != purpose {
+				continue
+			}
+			if token.UsedAt != nil {
+				return ErrTokenRevoked
+			}
+			now := time.Now().UTC()
+			if now.After(token.ExpiresAt) {
+				return ErrTokenExpired
+			}
+
+			token.UsedAt = &now
+			dbStructure.EmailTokens[id] = token
+			used = token
+			return nil
+		}
+		return ErrNotExist
+	})
+	if err != nil {
+		return EmailToken{}, err
+	}
+
+	return used, nil
+}
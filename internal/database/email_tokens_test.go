@@ -0,0 +1,53 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCreateEmailTokenPrunes(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	first, err := db.CreateEmailToken(1, EmailTokenReset, "walt@example.com", "first", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateEmailToken: %v", err)
+	}
+	if _, err := db.CreateEmailToken(2, EmailTokenReset, "jesse@example.com", "expired", now.Add(-time.Minute)); err != nil {
+		t.Fatalf("CreateEmailToken: %v", err)
+	}
+	if _, err := db.CreateEmailToken(3, EmailTokenVerify, "hank@example.com", "used", now.Add(time.Hour)); err != nil {
+		t.Fatalf("CreateEmailToken: %v", err)
+	}
+	if _, err := db.UseEmailToken(EmailTokenVerify, "used"); err != nil {
+		t.Fatalf("UseEmailToken: %v", err)
+	}
+	if _, err := db.CreateEmailToken(1, EmailTokenVerify, "walt@example.com", "verify", now.Add(time.Hour)); err != nil {
+		t.Fatalf("CreateEmailToken: %v", err)
+	}
+
+	// A new reset token replaces the first, and the used and expired
+	// tokens of other users go with it
+	if _, err := db.CreateEmailToken(1, EmailTokenReset, "walt@example.com", "second", now.Add(time.Hour)); err != nil {
+		t.Fatalf("CreateEmailToken: %v", err)
+	}
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		t.Fatalf("loadDB: %v", err)
+	}
+	var hashes []string
+	for _, token := range dbStructure.EmailTokens {
+		hashes = append(hashes, token.TokenHash)
+	}
+	if len(hashes) != 2 {
+		t.Errorf("tokens %v, want only verify and second", hashes)
+	}
+
+	if _, err := db.UseEmailToken(EmailTokenReset, first.TokenHash); !errors.Is(err, ErrNotExist) {
+		t.Errorf("replaced token: err = %v, want %v", err, ErrNotExist)
+	}
+	if _, err := db.UseEmailToken(EmailTokenReset, "second"); err != nil {
+		t.Errorf("latest token: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrAlreadyFollowing = errors.New("already following")
+
+type Follow struct {
+	ID         int       `json:"id"`
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// UserStats are the counts shown on a public profile.
+type UserStats struct {
+	Followers int
+	Following int
+	Chirps    int
+}
+
+func (db *DB) CreateFollow(followerID, followeeID int) (Follow, error) {
+	var follow Follow
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[followeeID]; !ok {
+			return ErrNotExist
+		}
+		for _, existing := range dbStructure.Follows {
+			if existing.FollowerID == followerID && existing.FolloweeID == followeeID {
+				follow = existing
+				return ErrAlreadyFollowing
+			}
+		}
+
+		id := nextID(dbStructure.Follows)
+		follow = Follow{
+			ID:         id,
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+			CreatedAt:  time.Now().UTC(),
+		}
+		dbStructure.Follows[id] = follow
+		return nil
+	})
+	if errors.Is(err, ErrAlreadyFollowing) {
+		return follow, err
+	}
+	if err != nil {
+		return Follow{}, err
+	}
+
+	return follow, nil
+}
+
+func (db *DB) DeleteFollow(followerID, followeeID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for id, follow := range dbStructure.Follows {
+			if follow.FollowerID == followerID && follow.FolloweeID == followeeID {
+				delete(dbStructure.Follows, id)
+				return nil
+			}
+		}
+		return ErrNotExist
+	})
+}
+
+func (db *DB) GetUserStats(userID int) (UserStats, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return UserStats{}, err
+	}
+
+	stats := UserStats{}
+	for _, follow := range dbStructure.Follows {
+		if follow.FolloweeID == userID {
+			stats.Followers++
+		}
+		if follow.FollowerID == userID {
+			stats.Following++
+		}
+	}
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID == userID {
+			stats.Chirps++
+		}
+	}
+
+	return stats, nil
+}
+
+// GetFolloweeIDs returns the IDs of the users userID follows.
+func (db *DB) GetFolloweeIDs(userID int) ([]int, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0)
+	for _, follow := range dbStructure.Follows {
+		if follow.FollowerID == userID {
+			ids = append(ids, follow.FolloweeID)
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package database
+
+import "os"
+
+// tryLock does nothing where flock isn't available; only one process may
+// use the database at a time there.
+func tryLock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	first := newTestDB(t)
+	if err := first.Lock(0); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	second, err := NewDB(first.path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	defer second.Close()
+	if err := second.Lock(0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock while held: err = %v, want %v", err, ErrLocked)
+	}
+
+	// A waiting process gets the lock once the holder closes the database
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		first.Close()
+	}()
+	if err := second.Lock(5 * time.Second); err != nil {
+		t.Errorf("Lock after Close: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package database
+
+import "time"
+
+// LoginThrottle tracks failed logins for one key, such as an account or an IP
+// address.
+type LoginThrottle struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// GetLoginLockout returns the latest time until which any of the keys is
+// locked. The zero time means none are.
+func (db *DB) GetLoginLockout(keys ...string) (time.Time, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	lockedUntil := time.Time{}
+	for _, key := range keys {
+		throttle := dbStructure.LoginThrottles[key]
+		if throttle.LockedUntil.After(lockedUntil) {
+			lockedUntil = throttle.LockedUntil
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+// RecordLoginFailure counts a failed login against key. Failures older than
+// resetAfter are forgotten first, and keys whose failures have all been
+// forgotten and that are no longer locked are dropped, so guesses against
+// made-up accounts don't pile up. lockFor decides, from the new failure
+// count, how long the key is locked.
+func (db *DB) RecordLoginFailure(key string, resetAfter time.Duration, lockFor func(failures int) time.Duration) (LoginThrottle, error) {
+	var throttle LoginThrottle
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for k, t := range dbStructure.LoginThrottles {
+			if now.Sub(t.LastFailure) > resetAfter && now.After(t.LockedUntil) {
+				delete(dbStructure.LoginThrottles, k)
+			}
+		}
+
+		throttle = dbStructure.LoginThrottles[key]
+		if now.Sub(throttle.LastFailure) > resetAfter {
+			throttle = LoginThrottle{}
+		}
+		throttle.Failures++
+		throttle.LastFailure = now
+		if d := lockFor(throttle.Failures); d > 0 {
+			throttle.LockedUntil = now.Add(d)
+		}
+		dbStructure.LoginThrottles[key] = throttle
+		return nil
+	})
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+
+	return throttle, nil
+}
+
+// ClearLoginFailures forgets the failed logins counted against key.
+func (db *DB) ClearLoginFailures(key string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.LoginThrottles[key]; !ok {
+			return errUnchanged
+		}
+		delete(dbStructure.LoginThrottles, key)
+		return nil
+	})
+}
+
+// GetLoginThrottles returns the throttle records stored under any of the
+// keys.
+func (db *DB) GetLoginThrottles(keys ...string) (map[string]LoginThrottle, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	throttles := map[string]LoginThrottle{}
+	for _, key := range keys {
+		if throttle, ok := dbStructure.LoginThrottles[key]; ok {
+			throttles[key] = throttle
+		}
+	}
+
+	return throttles, nil
+}
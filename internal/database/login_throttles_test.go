@@ -0,0 +1,59 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordLoginFailurePrunesExpiredKeys(t *testing.T) {
+	db := newTestDB(t)
+	noLock := func(int) time.Duration { return 0 }
+
+	_, err := db.RecordLoginFailure("email:old@example.com", time.Hour, noLock)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+
+	// A window this short has already passed for the first key
+	time.Sleep(10 * time.Millisecond)
+	throttle, err := db.RecordLoginFailure("email:new@example.com", time.Millisecond, noLock)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+	if throttle.Failures != 1 {
+		t.Errorf("Failures = %d, want 1", throttle.Failures)
+	}
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		t.Fatalf("loadDB: %v", err)
+	}
+	if _, ok := dbStructure.LoginThrottles["email:old@example.com"]; ok {
+		t.Error("expired key was kept")
+	}
+	if _, ok := dbStructure.LoginThrottles["email:new@example.com"]; !ok {
+		t.Error("new key was not stored")
+	}
+}
+
+func TestRecordLoginFailureKeepsLockedKeys(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.RecordLoginFailure("ip:192.0.2.1", time.Millisecond, func(int) time.Duration { return time.Hour })
+	if err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	_, err = db.RecordLoginFailure("ip:192.0.2.2", time.Millisecond, func(int) time.Duration { return 0 })
+	if err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+
+	lockedUntil, err := db.GetLoginLockout("ip:192.0.2.1")
+	if err != nil {
+		t.Fatalf("GetLoginLockout: %v", err)
+	}
+	if !lockedUntil.After(time.Now()) {
+		t.Error("a key still locked out was pruned")
+	}
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrMediaUnavailable = errors.New("media does not exist or is already attached")
+
+// Image is a stored image and its thumbnail, both kept in the blob store.
+type Image struct {
+	Key          string `json:"key"`
+	ThumbnailKey string `json:"thumbnail_key"`
+	ContentType  string `json:"content_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// Media is an image uploaded to be attached to a chirp. ChirpID is zero until
+// it is attached.
+type Media struct {
+	ID        int       `json:"id"`
+	OwnerID   int       `json:"owner_id"`
+	ChirpID   int       `json:"chirp_id,omitempty"`
+	Image     Image     `json:"image"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (db *DB) CreateMedia(ownerID int, image Image) (Media, error) {
+	var media Media
+	err := db.update(func(dbStructure *DBStructure) error {
+		id := nextID(dbStructure.Media)
+		media = Media{
+			ID:        id,
+			OwnerID:   ownerID,
+			Image:     image,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.Media[id] = media
+		return nil
+	})
+	if err != nil {
+		return Media{}, err
+	}
+
+	return media, nil
+}
+
+// GetMediaByChirpIDs returns the media attached to each of the chirps, in
+// upload order.
+func (db *DB) GetMediaByChirpIDs(chirpIDs []int) (map[int][]Media, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[int]bool, len(chirpIDs))
+	for _, id := range chirpIDs {
+		wanted[id] = true
+	}
+
+	media := map[int][]Media{}
+	for _, m := range dbStructure.Media {
+		if m.ChirpID != 0 && wanted[m.ChirpID] {
+			media[m.ChirpID] = append(media[m.ChirpID], m)
+		}
+	}
+	for _, list := range media {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].ID < list[j].ID
+		})
+	}
+
+	return media, nil
+}
+
+// DeleteMedia deletes media that hasn't been attached to a chirp yet.
+func (db *DB) DeleteMedia(id, ownerID int) (Media, error) {
+	var media Media
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		media, ok = dbStructure.Media[id]
+		if !ok || media.OwnerID != ownerID || media.ChirpID != 0 {
+			return ErrNotExist
+		}
+		delete(dbStructure.Media, id)
+		return nil
+	})
+	if err != nil {
+		return Media{}, err
+	}
+
+	return media, nil
+}
+
+// DeleteUnattachedMedia deletes the media uploaded before cutoff that were
+// never attached to a chirp and returns them, so their blobs can be deleted.
+func (db *DB) DeleteUnattachedMedia(cutoff time.Time) ([]Media, error) {
+	deleted := make([]Media, 0)
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, media := range dbStructure.Media {
+			if media.ChirpID == 0 && media.CreatedAt.Before(cutoff) {
+				delete(dbStructure.Media, id)
+				deleted = append(deleted, media)
+			}
+		}
+		if len(deleted) == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// SetUserAvatar replaces the user's avatar, returning the previous one so its
+// blobs can be deleted. A nil avatar removes it.
+func (db *DB) SetUserAvatar(userID int, avatar *Image) (*Image, error) {
+	var previous *Image
+	_, err := db.updateUser(userID, func(dbStructure *DBStructure, user *User) error {
+		previous = user.Avatar
+		user.Avatar = avatar
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
@@ -0,0 +1,210 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Kinds of notifications.
+const (
+	NotificationMention = "mention"
+	NotificationFollow  = "follow"
+	// NotificationChirpyRed reports a change to the user's Chirpy Red
+	// subscription; Detail says which
+	NotificationChirpyRed = "chirpy_red"
+	// NotificationModeration reports an action admins took on the account
+	NotificationModeration = "moderation"
+)
+
+var ErrNotificationMuted = errors.New("user muted this type of notification")
+
+// Notification is an entry in a user's inbox.
+type Notification struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Type   string `json:"type"`
+	// ActorID is the user who caused the notification, if any
+	ActorID int `json:"actor_id,omitempty"`
+	ChirpID int `json:"chirp_id,omitempty"`
+	// Detail qualifies the type, e.g. whether a subscription started or ended
+	Detail    string     `json:"detail,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+// CreateNotification adds the notification to its user's inbox, unless they
+// muted its type.
+func (db *DB) CreateNotification(notification Notification) (Notification, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[notification.UserID]
+		if !ok {
+			return ErrNotExist
+		}
+		if slices.Contains(user.MutedNotifications, notification.Type) {
+			return ErrNotificationMuted
+		}
+
+		notification.ID = nextID(dbStructure.Notifications)
+		notification.CreatedAt = time.Now().UTC()
+		notification.ReadAt = nil
+		dbStructure.Notifications[notification.ID] = notification
+		return nil
+	})
+	if err != nil {
+		return Notification{}, err
+	}
+
+	return notification, nil
+}
+
+// GetNotifications returns up to limit of the user's notifications, newest
+// first. With unreadOnly only unread ones are returned, and with before only
+// those with smaller IDs.
+func (db *DB) GetNotifications(userID int, unreadOnly bool, before, limit int) ([]Notification, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make([]Notification, 0)
+	for _, notification := range dbStructure.Notifications {
+		if notification.UserID != userID {
+			continue
+		}
+		if unreadOnly && notification.ReadAt != nil {
+			continue
+		}
+		if before > 0 && notification.ID >= before {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].ID > notifications[j].ID
+	})
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+
+	return notifications, nil
+}
+
+// GetNotificationsAfter returns up to limit of the user's latest
+// notifications with IDs above afterID, oldest first.
+func (db *DB) GetNotificationsAfter(userID, afterID, limit int) ([]Notification, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make([]Notification, 0)
+	for _, notification := range dbStructure.Notifications {
+		if notification.UserID == userID && notification.ID > afterID {
+			notifications = append(notifications, notification)
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].ID < notifications[j].ID
+	})
+	if len(notifications) > limit {
+		notifications = notifications[len(notifications)-limit:]
+	}
+
+	return notifications, nil
+}
+
+func (db *DB) CountUnreadNotifications(userID int) (int, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, notification := range dbStructure.Notifications {
+		if notification.UserID == userID && notification.ReadAt == nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// MarkNotificationsRead marks those of the given notifications that belong
+// to the user as read. It returns the IDs of the ones that were unread.
+func (db *DB) MarkNotificationsRead(userID int, ids []int) ([]int, error) {
+	marked := make([]int, 0)
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for _, id := range ids {
+			notification, ok := dbStructure.Notifications[id]
+			if !ok || notification.UserID != userID || notification.ReadAt != nil {
+				continue
+			}
+			notification.ReadAt = &now
+			dbStructure.Notifications[id] = notification
+			marked = append(marked, id)
+		}
+		if len(marked) == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(marked)
+
+	return marked, nil
+}
+
+// MarkAllNotificationsRead marks every unread notification of the user as
+// read and returns their IDs.
+func (db *DB) MarkAllNotificationsRead(userID int) ([]int, error) {
+	marked := make([]int, 0)
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for id, notification := range dbStructure.Notifications {
+			if notification.UserID != userID || notification.ReadAt != nil {
+				continue
+			}
+			notification.ReadAt = &now
+			dbStructure.Notifications[id] = notification
+			marked = append(marked, id)
+		}
+		if len(marked) == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(marked)
+
+	return marked, nil
+}
+
+// SetMutedNotifications replaces the notification types the user muted.
+func (db *DB) SetMutedNotifications(userID int, muted []string) (User, error) {
+	return db.updateUser(userID, func(dbStructure *DBStructure, user *User) error {
+		user.MutedNotifications = muted
+		return nil
+	})
+}
+
+// GetNotification returns one of the user's notifications.
+func (db *DB) GetNotification(userID, id int) (Notification, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Notification{}, err
+	}
+
+	notification, ok := dbStructure.Notifications[id]
+	if !ok || notification.UserID != userID {
+		return Notification{}, ErrNotExist
+	}
+
+	return notification, nil
+}
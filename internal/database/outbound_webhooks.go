@@ -0,0 +1,335 @@
+package database
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+)
+
+// States of an outbound delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryFailed deliveries ran out of attempts or their endpoint was
+	// disabled
+	DeliveryFailed = "failed"
+)
+
+// WebhookEndpoint is a URL a user, or an admin when OwnerID is 0, registered
+// to receive events. User endpoints only get events about that user; admin
+// endpoints get every event.
+type WebhookEndpoint struct {
+	ID      int      `json:"id"`
+	OwnerID int      `json:"owner_id"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	Events  []string `json:"events"`
+	// ConsecutiveFailures counts failed attempts since the last success
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// OutboundDelivery is one event queued for one endpoint. Deliveries are
+// stored before the first attempt, so they survive restarts, and retried
+// until they succeed or run out of attempts.
+type OutboundDelivery struct {
+	ID             int             `json:"id"`
+	EndpointID     int             `json:"endpoint_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// AttemptResult is the outcome of one delivery attempt. A zero
+// NextAttemptAt on a failure means the delivery is given up on.
+type AttemptResult struct {
+	Success       bool
+	StatusCode    int
+	Error         string
+	NextAttemptAt time.Time
+}
+
+func (db *DB) CreateWebhookEndpoint(ownerID int, url, secret string, events []string) (WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	err := db.update(func(dbStructure *DBStructure) error {
+		id := nextID(dbStructure.WebhookEndpoints)
+		endpoint = WebhookEndpoint{
+			ID:        id,
+			OwnerID:   ownerID,
+			URL:       url,
+			Secret:    secret,
+			Events:    events,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.WebhookEndpoints[id] = endpoint
+		return nil
+	})
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	return endpoint, nil
+}
+
+func (db *DB) GetWebhookEndpoints(ownerID int) ([]WebhookEndpoint, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]WebhookEndpoint, 0)
+	for _, endpoint := range dbStructure.WebhookEndpoints {
+		if endpoint.OwnerID == ownerID {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].ID < endpoints[j].ID
+	})
+
+	return endpoints, nil
+}
+
+// DeleteWebhookEndpoint deletes one of the owner's endpoints and drops its
+// queued deliveries.
+func (db *DB) DeleteWebhookEndpoint(ownerID, id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		endpoint, ok := dbStructure.WebhookEndpoints[id]
+		if !ok || endpoint.OwnerID != ownerID {
+			return ErrNotExist
+		}
+		deleteWebhookEndpoint(*dbStructure, id)
+		return nil
+	})
+}
+
+// EnableWebhookEndpoint re-enables an endpoint that was disabled after
+// repeated failures.
+func (db *DB) EnableWebhookEndpoint(ownerID, id int) (WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		endpoint, ok = dbStructure.WebhookEndpoints[id]
+		if !ok || endpoint.OwnerID != ownerID {
+			return ErrNotExist
+		}
+		endpoint.DisabledAt = nil
+		endpoint.ConsecutiveFailures = 0
+		dbStructure.WebhookEndpoints[id] = endpoint
+		return nil
+	})
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	return endpoint, nil
+}
+
+// GetOutboundDeliveries returns the latest deliveries to one of the owner's
+// endpoints, newest first.
+func (db *DB) GetOutboundDeliveries(ownerID, endpointID, limit int) ([]OutboundDelivery, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, ok := dbStructure.WebhookEndpoints[endpointID]
+	if !ok || endpoint.OwnerID != ownerID {
+		return nil, ErrNotExist
+	}
+
+	deliveries := make([]OutboundDelivery, 0)
+	for _, delivery := range dbStructure.OutboundDeliveries {
+		if delivery.EndpointID == endpointID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
+
+// EnqueueWebhookEvent queues the event for every enabled endpoint subscribed
+// to its type that belongs to userID or to an admin. It returns how many
+// deliveries were queued.
+func (db *DB) EnqueueWebhookEvent(eventID, eventType string, userID int, payload []byte) (int, error) {
+	queued := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for _, endpoint := range dbStructure.WebhookEndpoints {
+			if endpoint.DisabledAt != nil || !slices.Contains(endpoint.Events, eventType) {
+				continue
+			}
+			if endpoint.OwnerID != 0 && endpoint.OwnerID != userID {
+				continue
+			}
+			id := nextID(dbStructure.OutboundDeliveries)
+			dbStructure.OutboundDeliveries[id] = OutboundDelivery{
+				ID:            id,
+				EndpointID:    endpoint.ID,
+				EventID:       eventID,
+				EventType:     eventType,
+				Payload:       json.RawMessage(payload),
+				Status:        DeliveryPending,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			}
+			queued++
+		}
+		if queued == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return queued, nil
+}
+
+// GetDueDeliveries returns up to limit pending deliveries whose next attempt
+// is due, oldest first, with their endpoints. Due deliveries are offered to
+// skip in that order and left out if it returns true. It also returns when
+// the next delivery that isn't due yet is, or the zero time if there is none.
+func (db *DB) GetDueDeliveries(now time.Time, limit int, skip func(OutboundDelivery) bool) ([]OutboundDelivery, map[int]WebhookEndpoint, time.Time, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	due := make([]OutboundDelivery, 0)
+	var next time.Time
+	for _, delivery := range dbStructure.OutboundDeliveries {
+		if delivery.Status != DeliveryPending {
+			continue
+		}
+		if delivery.NextAttemptAt.After(now) {
+			if next.IsZero() || delivery.NextAttemptAt.Before(next) {
+				next = delivery.NextAttemptAt
+			}
+			continue
+		}
+		due = append(due, delivery)
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].ID < due[j].ID
+	})
+	due = slices.DeleteFunc(due, skip)
+	if len(due) > limit {
+		due = due[:limit]
+		next = now
+	}
+
+	endpoints := map[int]WebhookEndpoint{}
+	for _, delivery := range due {
+		endpoints[delivery.EndpointID] = dbStructure.WebhookEndpoints[delivery.EndpointID]
+	}
+
+	return due, endpoints, next, nil
+}
+
+// RecordDeliveryAttempt stores the outcome of an attempt. Failures count
+// against the endpoint, which is disabled once it has failed disableAfter
+// times in a row; its pending deliveries then fail too. It reports whether
+// the endpoint was disabled by this attempt.
+func (db *DB) RecordDeliveryAttempt(id int, result AttemptResult, disableAfter int) (disabled bool, err error) {
+	err = db.update(func(dbStructure *DBStructure) error {
+		delivery, ok := dbStructure.OutboundDeliveries[id]
+		if !ok {
+			return ErrNotExist
+		}
+		// The endpoint was disabled by another attempt while this one was
+		// under way
+		if delivery.Status == DeliveryFailed && !result.Success {
+			return errUnchanged
+		}
+		endpoint, endpointExists := dbStructure.WebhookEndpoints[delivery.EndpointID]
+
+		now := time.Now().UTC()
+		delivery.Attempts++
+		delivery.LastStatusCode = result.StatusCode
+		delivery.LastError = result.Error
+		switch {
+		case result.Success:
+			delivery.Status = DeliveryDelivered
+			delivery.DeliveredAt = &now
+			endpoint.ConsecutiveFailures = 0
+		case result.NextAttemptAt.IsZero():
+			delivery.Status = DeliveryFailed
+			endpoint.ConsecutiveFailures++
+		default:
+			delivery.NextAttemptAt = result.NextAttemptAt
+			endpoint.ConsecutiveFailures++
+		}
+		dbStructure.OutboundDeliveries[id] = delivery
+
+		if endpointExists {
+			if endpoint.DisabledAt == nil && endpoint.ConsecutiveFailures >= disableAfter {
+				endpoint.DisabledAt = &now
+				disabled = true
+				for otherID, other := range dbStructure.OutboundDeliveries {
+					if other.EndpointID == endpoint.ID && other.Status == DeliveryPending {
+						other.Status = DeliveryFailed
+						other.LastError = "endpoint disabled after repeated failures"
+						dbStructure.OutboundDeliveries[otherID] = other
+					}
+				}
+			}
+			dbStructure.WebhookEndpoints[endpoint.ID] = endpoint
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return disabled, nil
+}
+
+// PruneOutboundDeliveries deletes the delivered and failed deliveries queued
+// before cutoff and returns how many it deleted.
+func (db *DB) PruneOutboundDeliveries(cutoff time.Time) (int, error) {
+	pruned := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, delivery := range dbStructure.OutboundDeliveries {
+			if delivery.Status != DeliveryPending && delivery.CreatedAt.Before(cutoff) {
+				delete(dbStructure.OutboundDeliveries, id)
+				pruned++
+			}
+		}
+		if pruned == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, nil
+}
+
+// deleteWebhookEndpoint removes the endpoint and all its deliveries.
+func deleteWebhookEndpoint(dbStructure DBStructure, id int) {
+	delete(dbStructure.WebhookEndpoints, id)
+	for deliveryID, delivery := range dbStructure.OutboundDeliveries {
+		if delivery.EndpointID == id {
+			delete(dbStructure.OutboundDeliveries, deliveryID)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package database
+
+import "time"
+
+// lastUsedResolution is how stale a token's LastUsedAt may get before a use
+// updates it. Recording every use would rewrite the file on every request.
+const lastUsedResolution = time.Minute
+
+// PersonalAccessToken is a long-lived token for bots. Only the SHA-256 hash
+// of the token is stored.
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"token_hash"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (db *DB) CreatePersonalAccessToken(userID int, name, tokenHash string, scopes []string, expiresAt *time.Time) (PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	err := db.update(func(dbStructure *DBStructure) error {
+		id := nextID(dbStructure.PersonalAccessTokens)
+		token = PersonalAccessToken{
+			ID:        id,
+			UserID:    userID,
+			Name:      name,
+			TokenHash: tokenHash,
+			Scopes:    scopes,
+			CreatedAt: time.Now().UTC(),
+			ExpiresAt: expiresAt,
+		}
+		dbStructure.PersonalAccessTokens[id] = token
+		return nil
+	})
+	if err != nil {
+		return PersonalAccessToken{}, err
+	}
+
+	return token, nil
+}
+
+// GetPersonalAccessTokens returns the user's tokens that are not revoked.
+func (db *DB) GetPersonalAccessTokens(userID int) ([]PersonalAccessToken, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]PersonalAccessToken, 0)
+	for _, token := range dbStructure.PersonalAccessTokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+// UsePersonalAccessToken looks up a token by hash and records its use, to
+// within a minute. It returns ErrTokenRevoked or ErrTokenExpired for tokens
+// that can't be used, including tokens of suspended users.
+func (db *DB) UsePersonalAccessToken(tokenHash string) (PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	err := db.update(func(dbStructure *DBStructure) error {
+		found := false
+		for _, t := range dbStructure.PersonalAccessTokens {
+			if t.TokenHash == tokenHash {
+				token = t
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrNotExist
+		}
+
+		now := time.Now().UTC()
+		if token.RevokedAt != nil || dbStructure.Users[token.UserID].Suspended {
+			return ErrTokenRevoked
+		}
+		if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+			return ErrTokenExpired
+		}
+
+		if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < lastUsedResolution {
+			return errUnchanged
+		}
+		token.LastUsedAt = &now
+		dbStructure.PersonalAccessTokens[token.ID] = token
+		return nil
+	})
+	if err != nil {
+		return PersonalAccessToken{}, err
+	}
+
+	return token, nil
+}
+
+func (db *DB) RevokePersonalAccessToken(userID, id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		token, ok := dbStructure.PersonalAccessTokens[id]
+		if !ok || token.UserID != userID || token.RevokedAt != nil {
+			return ErrNotExist
+		}
+
+		now := time.Now().UTC()
+		token.RevokedAt = &now
+		dbStructure.PersonalAccessTokens[id] = token
+		return nil
+	})
+}
@@ -0,0 +1,322 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// refreshTokenPruneAfter is how long dead refresh tokens are kept. Until
+// then a rotated token that turns up again is still caught as reuse, and it
+// outlasts every access token, so the ID of a pruned session is never
+// presented again.
+const refreshTokenPruneAfter = 7 * 24 * time.Hour
+
+var (
+	ErrTokenExpired = errors.New("refresh token expired")
+	ErrTokenRevoked = errors.New("refresh token revoked")
+	ErrTokenReused  = errors.New("refresh token reused")
+)
+
+// RefreshToken is a single link in a rotation chain. Every token issued from
+// the same login shares a FamilyID, which is the ID of the first token in the
+// chain. Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	FamilyID   int        `json:"family_id"`
+	TokenHash  string     `json:"token_hash"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	ReplacedBy int        `json:"replaced_by,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	ClientInfo
+}
+
+// ClientInfo describes the device a refresh token was issued to.
+type ClientInfo struct {
+	DeviceLabel string `json:"device_label"`
+	IP          string `json:"ip"`
+	UserAgent   string `json:"user_agent"`
+}
+
+// Session is the view of a token family as a login on one device. Its ID is
+// the family ID.
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	ClientInfo
+}
+
+// CreateRefreshToken starts a new token family for the user.
+func (db *DB) CreateRefreshToken(userID int, tokenHash string, expiresAt time.Time, client ClientInfo) (RefreshToken, error) {
+	var token RefreshToken
+	err := db.update(func(dbStructure *DBStructure) error {
+		pruneRefreshTokens(*dbStructure, time.Now().UTC())
+
+		id := nextID(dbStructure.RefreshTokens)
+		token = RefreshToken{
+			ID:         id,
+			UserID:     userID,
+			FamilyID:   id,
+			TokenHash:  tokenHash,
+			CreatedAt:  time.Now().UTC(),
+			ExpiresAt:  expiresAt,
+			ClientInfo: client,
+		}
+		dbStructure.RefreshTokens[id] = token
+		return nil
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken exchanges the token matching tokenHash for a new one in
+// the same family. Presenting a token that has already been rotated is
+// treated as theft: the whole family is revoked and ErrTokenReused returned.
+// The device label is carried over; IP and user agent are taken from client.
+func (db *DB) RotateRefreshToken(tokenHash, newTokenHash string, expiresAt time.Time, client ClientInfo) (RefreshToken, error) {
+	var token RefreshToken
+	reused := false
+	err := db.update(func(dbStructure *DBStructure) error {
+		old, ok := findRefreshToken(*dbStructure, tokenHash)
+		if !ok {
+			return ErrNotExist
+		}
+
+		now := time.Now().UTC()
+		if old.RevokedAt != nil {
+			return ErrTokenRevoked
+		}
+		if old.ReplacedBy != 0 {
+			// The revocation has to be written, so this isn't an error yet
+			revokeFamily(*dbStructure, old.FamilyID, now)
+			reused = true
+			return nil
+		}
+		if now.After(old.ExpiresAt) {
+			return ErrTokenExpired
+		}
+
+		pruneRefreshTokens(*dbStructure, now)
+		id := nextID(dbStructure.RefreshTokens)
+		token = RefreshToken{
+			ID:        id,
+			UserID:    old.UserID,
+			FamilyID:  old.FamilyID,
+			TokenHash: newTokenHash,
+			CreatedAt: now,
+			ExpiresAt: expiresAt,
+			ClientInfo: ClientInfo{
+				DeviceLabel: old.DeviceLabel,
+				IP:          client.IP,
+				UserAgent:   client.UserAgent,
+			},
+		}
+		dbStructure.RefreshTokens[id] = token
+
+		old.ReplacedBy = id
+		dbStructure.RefreshTokens[old.ID] = old
+		return nil
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if reused {
+		return RefreshToken{}, ErrTokenReused
+	}
+
+	return token, nil
+}
+
+// RevokeRefreshToken revokes every token in the family of the token matching
+// tokenHash, ending that login session.
+func (db *DB) RevokeRefreshToken(tokenHash string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		token, ok := findRefreshToken(*dbStructure, tokenHash)
+		if !ok {
+			return ErrNotExist
+		}
+
+		revokeFamily(*dbStructure, token.FamilyID, time.Now().UTC())
+		return nil
+	})
+}
+
+// GetSessions returns the user's active sessions.
+func (db *DB) GetSessions(userID int) ([]Session, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	sessions := make([]Session, 0)
+	for _, token := range dbStructure.RefreshTokens {
+		if token.UserID != userID || !token.active(now) {
+			continue
+		}
+		first := dbStructure.RefreshTokens[token.FamilyID]
+		sessions = append(sessions, Session{
+			ID:         token.FamilyID,
+			UserID:     token.UserID,
+			CreatedAt:  first.CreatedAt,
+			LastUsedAt: token.CreatedAt,
+			ExpiresAt:  token.ExpiresAt,
+			ClientInfo: token.ClientInfo,
+		})
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes one of the user's active sessions.
+func (db *DB) RevokeSession(userID, sessionID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		found := false
+		for _, token := range dbStructure.RefreshTokens {
+			if token.UserID == userID && token.FamilyID == sessionID && token.active(now) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrNotExist
+		}
+
+		revokeFamily(*dbStructure, sessionID, now)
+		return nil
+	})
+}
+
+// RevokeOtherSessions revokes all of the user's sessions except keepSessionID.
+// Pass 0 to revoke every session.
+func (db *DB) RevokeOtherSessions(userID, keepSessionID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for _, token := range dbStructure.RefreshTokens {
+			if token.UserID == userID && token.FamilyID != keepSessionID {
+				revokeFamily(*dbStructure, token.FamilyID, now)
+			}
+		}
+		return nil
+	})
+}
+
+// RevokeAllUserTokens signs the user out everywhere: every session is revoked
+// and access tokens issued up to now stop validating.
+func (db *DB) RevokeAllUserTokens(userID int) error {
+	_, err := db.updateUser(userID, func(dbStructure *DBStructure, user *User) error {
+		revokeUserTokens(*dbStructure, user, time.Now().UTC())
+		return nil
+	})
+	return err
+}
+
+// CheckAccessToken reports ErrTokenRevoked if an access token issued to the
+// user at issuedAt for the given session may no longer be used: the user is
+// suspended, the token predates the user's watermark, or the session has been
+// revoked. Tokens without a session (sessionID 0) skip the session check.
+func (db *DB) CheckAccessToken(userID, sessionID int, issuedAt time.Time) error {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return ErrNotExist
+	}
+	if user.Suspended || issuedAt.Before(user.TokensValidAfter) {
+		return ErrTokenRevoked
+	}
+	if sessionID == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	for _, token := range dbStructure.RefreshTokens {
+		if token.FamilyID == sessionID && token.UserID == userID && token.RevokedAt == nil && now.Before(token.ExpiresAt) {
+			return nil
+		}
+	}
+	return ErrTokenRevoked
+}
+
+// active reports whether the token is the live head of its family.
+func (token RefreshToken) active(now time.Time) bool {
+	return token.RevokedAt == nil && token.ReplacedBy == 0 && now.Before(token.ExpiresAt)
+}
+
+func findRefreshToken(dbStructure DBStructure, tokenHash string) (RefreshToken, bool) {
+	for _, token := range dbStructure.RefreshTokens {
+		if token.TokenHash == tokenHash {
+			return token, true
+		}
+	}
+	return RefreshToken{}, false
+}
+
+// pruneRefreshTokens deletes the tokens that died more than
+// refreshTokenPruneAfter ago: whole families once none of their tokens can
+// be used, and rotated tokens of live families once they have expired. The
+// first token of a live family is kept, since the session's start time
+// comes from it.
+func pruneRefreshTokens(dbStructure DBStructure, now time.Time) {
+	cutoff := now.Add(-refreshTokenPruneAfter)
+
+	// A family's end is when its last token expired or it was revoked
+	alive := map[int]bool{}
+	ended := map[int]time.Time{}
+	for _, token := range dbStructure.RefreshTokens {
+		if token.active(now) {
+			alive[token.FamilyID] = true
+		}
+		end := token.ExpiresAt
+		if token.RevokedAt != nil && token.RevokedAt.Before(end) {
+			end = *token.RevokedAt
+		}
+		if end.After(ended[token.FamilyID]) {
+			ended[token.FamilyID] = end
+		}
+	}
+
+	for id, token := range dbStructure.RefreshTokens {
+		if alive[token.FamilyID] {
+			if id != token.FamilyID && token.ReplacedBy != 0 && token.ExpiresAt.Before(cutoff) {
+				delete(dbStructure.RefreshTokens, id)
+			}
+			continue
+		}
+		if ended[token.FamilyID].Before(cutoff) {
+			delete(dbStructure.RefreshTokens, id)
+		}
+	}
+}
+
+func revokeFamily(dbStructure DBStructure, familyID int, at time.Time) {
+	for id, token := range dbStructure.RefreshTokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &at
+			dbStructure.RefreshTokens[id] = token
+		}
+	}
+}
+
+// revokeUserTokens revokes all of the user's sessions and moves their token
+// watermark to now. JWT issue times have one-second precision, so the
+// watermark is truncated to match.
+func revokeUserTokens(dbStructure DBStructure, user *User, at time.Time) {
+	for _, token := range dbStructure.RefreshTokens {
+		if token.UserID == user.ID {
+			revokeFamily(dbStructure, token.FamilyID, at)
+		}
+	}
+	user.TokensValidAfter = at.Truncate(time.Second)
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	db := newTestDB(t)
+	expiresAt := time.Now().Add(time.Hour)
+
+	first, err := db.CreateRefreshToken(1, "hash-1", expiresAt, ClientInfo{DeviceLabel: "laptop"})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	second, err := db.RotateRefreshToken("hash-1", "hash-2", expiresAt, ClientInfo{IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if second.FamilyID != first.FamilyID {
+		t.Errorf("FamilyID = %d, want %d", second.FamilyID, first.FamilyID)
+	}
+	if second.DeviceLabel != "laptop" || second.IP != "192.0.2.1" {
+		t.Errorf("ClientInfo = %+v, want label carried over and new IP", second.ClientInfo)
+	}
+
+	third, err := db.RotateRefreshToken("hash-2", "hash-3", expiresAt, ClientInfo{})
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if third.FamilyID != first.FamilyID {
+		t.Errorf("FamilyID = %d, want %d", third.FamilyID, first.FamilyID)
+	}
+}
+
+func TestRotateRefreshTokenReuseRevokesSession(t *testing.T) {
+	db := newTestDB(t)
+	expiresAt := time.Now().Add(time.Hour)
+
+	_, err := db.CreateRefreshToken(1, "hash-1", expiresAt, ClientInfo{})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	_, err = db.RotateRefreshToken("hash-1", "hash-2", expiresAt, ClientInfo{})
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+
+	_, err = db.RotateRefreshToken("hash-1", "hash-stolen", expiresAt, ClientInfo{})
+	if !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("reusing a rotated token: err = %v, want ErrTokenReused", err)
+	}
+
+	// The legitimate head of the chain dies with the rest of the session
+	_, err = db.RotateRefreshToken("hash-2", "hash-3", expiresAt, ClientInfo{})
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("rotating after reuse: err = %v, want ErrTokenRevoked", err)
+	}
+	sessions, err := db.GetSessions(1)
+	if err != nil {
+		t.Fatalf("GetSessions: %v", err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("sessions after reuse = %d, want 0", len(sessions))
+	}
+}
+
+func TestRotateRefreshTokenConcurrently(t *testing.T) {
+	db := newTestDB(t)
+	expiresAt := time.Now().Add(time.Hour)
+
+	for round := 0; round < 20; round++ {
+		hash := fmt.Sprintf("hash-%d", round)
+		_, err := db.CreateRefreshToken(1, hash, expiresAt, ClientInfo{})
+		if err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+
+		const racers = 8
+		var wg sync.WaitGroup
+		errs := make(chan error, racers)
+		for i := 0; i < racers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := db.RotateRefreshToken(hash, fmt.Sprintf("%s-next-%d", hash, i), expiresAt, ClientInfo{})
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		rotated := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				rotated++
+			case errors.Is(err, ErrTokenReused), errors.Is(err, ErrTokenRevoked):
+			default:
+				t.Fatalf("RotateRefreshToken: %v", err)
+			}
+		}
+		if rotated != 1 {
+			t.Fatalf("round %d: token rotated %d times, want 1", round, rotated)
+		}
+	}
+}
+
+func TestRefreshTokensPruned(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().UTC()
+	long := now.Add(time.Hour)
+	// Expired long enough ago to be pruned, and too recently to be
+	ancient := now.Add(-refreshTokenPruneAfter - time.Hour)
+	recent := now.Add(-time.Hour)
+
+	// A live session whose first rotation expired long ago
+	live, err := db.CreateRefreshToken(1, "live-1", long, ClientInfo{})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	for _, hashes := range [][2]string{{"live-1", "live-2"}, {"live-2", "live-3"}} {
+		if _, err := db.RotateRefreshToken(hashes[0], hashes[1], long, ClientInfo{}); err != nil {
+			t.Fatalf("RotateRefreshToken: %v", err)
+		}
+	}
+	// Sessions that expired long ago and recently, and one revoked long ago
+	if _, err := db.CreateRefreshToken(1, "expired-long-ago", ancient, ClientInfo{}); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if _, err := db.CreateRefreshToken(1, "expired-recently", recent, ClientInfo{}); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if _, err := db.CreateRefreshToken(1, "revoked", long, ClientInfo{}); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	err = db.update(func(dbStructure *DBStructure) error {
+		for id, token := range dbStructure.RefreshTokens {
+			switch token.TokenHash {
+			case "live-2":
+				token.ExpiresAt = ancient
+			case "revoked":
+				token.RevokedAt = &ancient
+			}
+			dbStructure.RefreshTokens[id] = token
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	// Logging in prunes
+	if _, err := db.CreateRefreshToken(2, "new-login", long, ClientInfo{}); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		t.Fatalf("loadDB: %v", err)
+	}
+	kept := map[string]bool{}
+	for _, token := range dbStructure.RefreshTokens {
+		kept[token.TokenHash] = true
+	}
+	for hash, want := range map[string]bool{
+		"live-1":           true,
+		"live-2":           false,
+		"live-3":           true,
+		"expired-long-ago": false,
+		"expired-recently": true,
+		"revoked":          false,
+		"new-login":        true,
+	} {
+		if kept[hash] != want {
+			t.Errorf("%s kept = %v, want %v", hash, kept[hash], want)
+		}
+	}
+
+	// The session still starts when it did, and a rotated token that wasn't
+	// pruned is still caught as reuse
+	sessions, err := db.GetSessions(1)
+	if err != nil {
+		t.Fatalf("GetSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != live.ID || !sessions[0].CreatedAt.Equal(live.CreatedAt) {
+		t.Errorf("sessions = %+v, want the live session started at %v", sessions, live.CreatedAt)
+	}
+	if _, err := db.RotateRefreshToken("live-1", "stolen", long, ClientInfo{}); !errors.Is(err, ErrTokenReused) {
+		t.Errorf("reusing the first token: err = %v, want %v", err, ErrTokenReused)
+	}
+}
@@ -0,0 +1,99 @@
+package database
+
+import "time"
+
+// Reasons a subscription ended.
+const (
+	SubscriptionDowngraded = "downgraded"
+	SubscriptionExpired    = "expired"
+)
+
+// Subscription is a Chirpy Red subscription. It is active from StartedAt
+// until ExpiresAt, or until it is ended early. Subscriptions without an
+// expiry date stay active until ended.
+type Subscription struct {
+	StartedAt time.Time  `json:"started_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	EndReason string     `json:"end_reason,omitempty"`
+}
+
+// Active reports whether the subscription grants Chirpy Red at now. Expired
+// subscriptions lapse on their own, without waiting for an event.
+func (s *Subscription) Active(now time.Time) bool {
+	if s == nil || s.EndedAt != nil {
+		return false
+	}
+	return s.ExpiresAt == nil || now.Before(*s.ExpiresAt)
+}
+
+// IsChirpyRed reports whether the user currently has Chirpy Red.
+func (user User) IsChirpyRed() bool {
+	return user.Subscription.Active(time.Now().UTC())
+}
+
+// UpgradeUser gives the user Chirpy Red until expiresAt. An active
+// subscription is extended, never shortened; otherwise a new one starts.
+func (db *DB) UpgradeUser(id int, expiresAt time.Time) (User, error) {
+	return db.updateUser(id, func(dbStructure *DBStructure, user *User) error {
+		now := time.Now().UTC()
+		if user.Subscription.Active(now) {
+			sub := *user.Subscription
+			if sub.ExpiresAt != nil && expiresAt.After(*sub.ExpiresAt) {
+				sub.ExpiresAt = &expiresAt
+			}
+			user.Subscription = &sub
+		} else {
+			user.Subscription = &Subscription{
+				StartedAt: now,
+				ExpiresAt: &expiresAt,
+			}
+		}
+		return nil
+	})
+}
+
+// EndSubscription ends the user's subscription now. Ending one that isn't
+// active does nothing.
+func (db *DB) EndSubscription(id int, reason string) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		now := time.Now().UTC()
+		if !user.Subscription.Active(now) {
+			return errUnchanged
+		}
+
+		sub := *user.Subscription
+		sub.EndedAt = &now
+		sub.EndReason = reason
+		user.Subscription = &sub
+		dbStructure.Users[id] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// migrateLegacyChirpyRed turns the Chirpy Red flag of older databases into
+// an open-ended subscription, since when it would end was never recorded.
+func migrateLegacyChirpyRed(dbStructure DBStructure) {
+	for id, user := range dbStructure.Users {
+		if !user.LegacyChirpyRed {
+			continue
+		}
+		if user.Subscription == nil {
+			user.Subscription = &Subscription{StartedAt: user.CreatedAt}
+		}
+		user.LegacyChirpyRed = false
+		dbStructure.Users[id] = user
+	}
+}
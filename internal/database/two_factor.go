@@ -0,0 +1,107 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrCodeUsed = errors.New("code already used")
+
+// TwoFactor holds a user's TOTP enrollment. The secret is stored encrypted;
+// recovery codes are stored as SHA-256 hashes and removed once used.
+type TwoFactor struct {
+	Secret        string   `json:"secret,omitempty"`
+	Enabled       bool     `json:"enabled"`
+	LastStep      int64    `json:"last_step,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// SetTwoFactorSecret starts a new, unconfirmed enrollment for the user.
+func (db *DB) SetTwoFactorSecret(userID int, encryptedSecret string) error {
+	return db.updateTwoFactor(userID, func(tf *TwoFactor) error {
+		*tf = TwoFactor{Secret: encryptedSecret}
+		return nil
+	})
+}
+
+// EnableTwoFactor confirms the pending enrollment. step is the time step of
+// the code used to confirm it.
+func (db *DB) EnableTwoFactor(userID int, step int64, recoveryCodeHashes []string) error {
+	return db.updateTwoFactor(userID, func(tf *TwoFactor) error {
+		tf.Enabled = true
+		tf.LastStep = step
+		tf.RecoveryCodes = recoveryCodeHashes
+		return nil
+	})
+}
+
+func (db *DB) DisableTwoFactor(userID int) error {
+	return db.updateTwoFactor(userID, func(tf *TwoFactor) error {
+		*tf = TwoFactor{}
+		return nil
+	})
+}
+
+// UseTwoFactorStep records that a code from the given time step was used.
+// Each step is accepted at most once, so an observed code can't be replayed.
+func (db *DB) UseTwoFactorStep(userID int, step int64) error {
+	return db.updateTwoFactor(userID, func(tf *TwoFactor) error {
+		if step <= tf.LastStep {
+			return ErrCodeUsed
+		}
+		tf.LastStep = step
+		return nil
+	})
+}
+
+// UseRecoveryCode consumes the recovery code with the given hash.
+func (db *DB) UseRecoveryCode(userID int, codeHash string) error {
+	return db.updateTwoFactor(userID, func(tf *TwoFactor) error {
+		i := slices.Index(tf.RecoveryCodes, codeHash)
+		if i < 0 {
+			return ErrNotExist
+		}
+		tf.RecoveryCodes = slices.Delete(tf.RecoveryCodes, i, i+1)
+		return nil
+	})
+}
+
+// UseTwoFactorChallenge spends the login challenge with the given ID, which
+// expires at expiresAt. It reports ErrTokenRevoked for a challenge that was
+// already spent.
+func (db *DB) UseTwoFactorChallenge(id string, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		if usedUntil, ok := dbStructure.UsedChallenges[id]; ok && now.Before(usedUntil) {
+			return ErrTokenRevoked
+		}
+
+		for challengeID, usedUntil := range dbStructure.UsedChallenges {
+			if !now.Before(usedUntil) {
+				delete(dbStructure.UsedChallenges, challengeID)
+			}
+		}
+		dbStructure.UsedChallenges[id] = expiresAt
+		return nil
+	})
+}
+
+// TwoFactorChallengeUsed reports whether the login challenge with the given
+// ID was already spent.
+func (db *DB) TwoFactorChallengeUsed(id string) (bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	usedUntil, ok := dbStructure.UsedChallenges[id]
+	return ok && time.Now().UTC().Before(usedUntil), nil
+}
+
+func (db *DB) updateTwoFactor(userID int, update func(*TwoFactor) error) error {
+	_, err := db.updateUser(userID, func(dbStructure *DBStructure, user *User) error {
+		return update(&user.TwoFactor)
+	})
+	return err
+}
@@ -0,0 +1,260 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrWebhookReplayed  = errors.New("webhook delivery was already received")
+	ErrWebhookEventBusy = errors.New("webhook event is already being processed")
+	ErrWebhookEventDone = errors.New("webhook event was already processed")
+)
+
+// webhookClaimTimeout is how long a claim on an event lasts. A claim older
+// than this was left by a process that stopped mid-way, so it can be taken
+// over.
+const webhookClaimTimeout = 5 * time.Minute
+
+// UseWebhookDelivery remembers the ID of a webhook delivery until expiresAt,
+// after which the delivery's timestamp is too old to be accepted anyway. It
+// reports ErrWebhookReplayed for a delivery seen before.
+func (db *DB) UseWebhookDelivery(id string, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		if seenUntil, ok := dbStructure.WebhookDeliveries[id]; ok && now.Before(seenUntil) {
+			return ErrWebhookReplayed
+		}
+
+		for seen, seenUntil := range dbStructure.WebhookDeliveries {
+			if !now.Before(seenUntil) {
+				delete(dbStructure.WebhookDeliveries, seen)
+			}
+		}
+		dbStructure.WebhookDeliveries[id] = expiresAt
+		return nil
+	})
+}
+
+// Processing states of a webhook event.
+const (
+	WebhookPending = "pending"
+	// WebhookProcessing events have been claimed by a caller applying them
+	WebhookProcessing = "processing"
+	WebhookProcessed  = "processed"
+	// WebhookIgnored events were valid but of a type we don't act on
+	WebhookIgnored = "ignored"
+	WebhookFailed  = "failed"
+)
+
+// WebhookEvent is a received webhook event and the outcome of processing
+// it. EventID is the sender's ID, which makes redeliveries idempotent.
+type WebhookEvent struct {
+	ID            int             `json:"id"`
+	Source        string          `json:"source"`
+	EventID       string          `json:"event_id"`
+	Type          string          `json:"type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Error         string          `json:"error,omitempty"`
+	Attempts      int             `json:"attempts"`
+	ReceivedAt    time.Time       `json:"received_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at,omitempty"`
+	ProcessedAt   *time.Time      `json:"processed_at,omitempty"`
+	ClaimedAt     *time.Time      `json:"claimed_at,omitempty"`
+}
+
+// Done reports whether the event needs no more processing.
+func (event WebhookEvent) Done() bool {
+	return event.Status == WebhookProcessed || event.Status == WebhookIgnored
+}
+
+// RecordWebhookEvent stores a newly received event as pending. If the source
+// already sent an event with this ID, that event is returned instead and
+// created is false.
+func (db *DB) RecordWebhookEvent(source, eventID, eventType string, payload []byte) (event WebhookEvent, created bool, err error) {
+	err = db.update(func(dbStructure *DBStructure) error {
+		for _, existing := range dbStructure.WebhookEvents {
+			if existing.Source == source && existing.EventID == eventID {
+				event = existing
+				return errUnchanged
+			}
+		}
+
+		id := nextID(dbStructure.WebhookEvents)
+		event = WebhookEvent{
+			ID:         id,
+			Source:     source,
+			EventID:    eventID,
+			Type:       eventType,
+			Payload:    json.RawMessage(payload),
+			Status:     WebhookPending,
+			ReceivedAt: time.Now().UTC(),
+		}
+		dbStructure.WebhookEvents[id] = event
+		created = true
+		return nil
+	})
+	if err != nil {
+		return WebhookEvent{}, false, err
+	}
+
+	return event, created, nil
+}
+
+// ClaimWebhookEvent marks the event as processing, so that only one caller
+// applies it at a time. It reports ErrWebhookEventBusy while another claim
+// holds, and ErrWebhookEventDone for an event needing no more processing
+// unless redo is set. The claim ends with FinishWebhookEvent.
+func (db *DB) ClaimWebhookEvent(id int, redo bool) (WebhookEvent, error) {
+	var event WebhookEvent
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		event, ok = dbStructure.WebhookEvents[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		now := time.Now().UTC()
+		if event.Status == WebhookProcessing && event.ClaimedAt != nil && now.Sub(*event.ClaimedAt) < webhookClaimTimeout {
+			return ErrWebhookEventBusy
+		}
+		if event.Done() && !redo {
+			return ErrWebhookEventDone
+		}
+
+		event.Status = WebhookProcessing
+		event.ClaimedAt = &now
+		dbStructure.WebhookEvents[id] = event
+		return nil
+	})
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	return event, nil
+}
+
+// FinishWebhookEvent records the outcome of an attempt to process the event
+// and releases its claim.
+func (db *DB) FinishWebhookEvent(id int, status, errMsg string) (WebhookEvent, error) {
+	var event WebhookEvent
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		event, ok = dbStructure.WebhookEvents[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		now := time.Now().UTC()
+		event.Status = status
+		event.Error = errMsg
+		event.Attempts++
+		event.LastAttemptAt = &now
+		event.ClaimedAt = nil
+		if event.Done() {
+			event.ProcessedAt = &now
+		}
+		dbStructure.WebhookEvents[id] = event
+		return nil
+	})
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	return event, nil
+}
+
+// PruneWebhookEvents deletes the events that were done with, or last failed,
+// before cutoff and returns how many it deleted. Pending events and events
+// being processed are kept.
+func (db *DB) PruneWebhookEvents(cutoff time.Time) (int, error) {
+	pruned := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, event := range dbStructure.WebhookEvents {
+			finishedAt := event.ProcessedAt
+			if event.Status == WebhookFailed {
+				finishedAt = event.LastAttemptAt
+			}
+			if finishedAt != nil && finishedAt.Before(cutoff) && (event.Done() || event.Status == WebhookFailed) {
+				delete(dbStructure.WebhookEvents, id)
+				pruned++
+			}
+		}
+		if pruned == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, nil
+}
+
+// GetWebhookEvents returns up to limit events, newest first, optionally only
+// those with the given status and with IDs below before.
+func (db *DB) GetWebhookEvents(status string, before, limit int) ([]WebhookEvent, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]WebhookEvent, 0)
+	for _, event := range dbStructure.WebhookEvents {
+		if status != "" && event.Status != status {
+			continue
+		}
+		if before > 0 && event.ID >= before {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID > events[j].ID
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	return events, nil
+}
+
+// FindWebhookEvents returns the events from source that match, oldest first.
+func (db *DB) FindWebhookEvents(source string, match func(WebhookEvent) bool) ([]WebhookEvent, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]WebhookEvent, 0)
+	for _, event := range dbStructure.WebhookEvents {
+		if event.Source == source && match(event) {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+
+	return events, nil
+}
+
+func (db *DB) GetWebhookEvent(id int) (WebhookEvent, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	event, ok := dbStructure.WebhookEvents[id]
+	if !ok {
+		return WebhookEvent{}, ErrNotExist
+	}
+
+	return event, nil
+}
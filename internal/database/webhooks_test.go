@@ -0,0 +1,151 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClaimWebhookEvent(t *testing.T) {
+	db := newTestDB(t)
+
+	event, _, err := db.RecordWebhookEvent("polka", "evt_1", "user.upgraded", []byte(`{}`))
+	if err != nil {
+		t.Fatalf("RecordWebhookEvent: %v", err)
+	}
+
+	claimed, err := db.ClaimWebhookEvent(event.ID, false)
+	if err != nil {
+		t.Fatalf("ClaimWebhookEvent: %v", err)
+	}
+	if claimed.Status != WebhookProcessing {
+		t.Errorf("Status = %q, want %q", claimed.Status, WebhookProcessing)
+	}
+	if _, err := db.ClaimWebhookEvent(event.ID, true); !errors.Is(err, ErrWebhookEventBusy) {
+		t.Errorf("claiming a claimed event: err = %v, want %v", err, ErrWebhookEventBusy)
+	}
+
+	if _, err := db.FinishWebhookEvent(event.ID, WebhookFailed, "user not found"); err != nil {
+		t.Fatalf("FinishWebhookEvent: %v", err)
+	}
+	if _, err := db.ClaimWebhookEvent(event.ID, false); err != nil {
+		t.Fatalf("claiming a failed event: %v", err)
+	}
+	if _, err := db.FinishWebhookEvent(event.ID, WebhookProcessed, ""); err != nil {
+		t.Fatalf("FinishWebhookEvent: %v", err)
+	}
+
+	if _, err := db.ClaimWebhookEvent(event.ID, false); !errors.Is(err, ErrWebhookEventDone) {
+		t.Errorf("claiming a processed event: err = %v, want %v", err, ErrWebhookEventDone)
+	}
+	if _, err := db.ClaimWebhookEvent(event.ID, true); err != nil {
+		t.Errorf("claiming a processed event to redo it: %v", err)
+	}
+}
+
+func TestClaimWebhookEventConcurrently(t *testing.T) {
+	db := newTestDB(t)
+
+	event, _, err := db.RecordWebhookEvent("polka", "evt_1", "user.upgraded", []byte(`{}`))
+	if err != nil {
+		t.Fatalf("RecordWebhookEvent: %v", err)
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claims := 0
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.ClaimWebhookEvent(event.ID, false)
+			if err == nil {
+				mu.Lock()
+				claims++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrWebhookEventBusy) {
+				t.Errorf("ClaimWebhookEvent: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if claims != 1 {
+		t.Errorf("%d callers claimed the event, want 1", claims)
+	}
+}
+
+func TestPruneWebhookEvents(t *testing.T) {
+	db := newTestDB(t)
+
+	statuses := []string{WebhookProcessed, WebhookIgnored, WebhookFailed, ""}
+	for i, status := range statuses {
+		event, _, err := db.RecordWebhookEvent("polka", "evt_"+status, "user.upgraded", []byte(`{}`))
+		if err != nil {
+			t.Fatalf("RecordWebhookEvent %d: %v", i, err)
+		}
+		if status != "" {
+			if _, err := db.FinishWebhookEvent(event.ID, status, ""); err != nil {
+				t.Fatalf("FinishWebhookEvent: %v", err)
+			}
+		}
+	}
+
+	if pruned, err := db.PruneWebhookEvents(time.Now().Add(-time.Hour)); err != nil || pruned != 0 {
+		t.Errorf("PruneWebhookEvents(an hour ago) = %d, %v; want 0", pruned, err)
+	}
+	pruned, err := db.PruneWebhookEvents(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("PruneWebhookEvents: %v", err)
+	}
+	if pruned != 3 {
+		t.Errorf("pruned %d events, want 3", pruned)
+	}
+	events, err := db.GetWebhookEvents("", 0, 10)
+	if err != nil {
+		t.Fatalf("GetWebhookEvents: %v", err)
+	}
+	if len(events) != 1 || events[0].Status != WebhookPending {
+		t.Errorf("events = %+v, want only the pending one", events)
+	}
+}
+
+func TestPruneOutboundDeliveries(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.CreateWebhookEndpoint(0, "https://example.com/hook", "secret", []string{"chirp.created"}); err != nil {
+		t.Fatalf("CreateWebhookEndpoint: %v", err)
+	}
+	for _, eventID := range []string{"evt_1", "evt_2", "evt_3"} {
+		if _, err := db.EnqueueWebhookEvent(eventID, "chirp.created", 1, []byte(`{}`)); err != nil {
+			t.Fatalf("EnqueueWebhookEvent: %v", err)
+		}
+	}
+	deliveries, _, _, err := db.GetDueDeliveries(time.Now(), 10, func(OutboundDelivery) bool { return false })
+	if err != nil || len(deliveries) != 3 {
+		t.Fatalf("GetDueDeliveries = %v, %v", deliveries, err)
+	}
+	if _, err := db.RecordDeliveryAttempt(deliveries[0].ID, AttemptResult{Success: true, StatusCode: 204}, 20); err != nil {
+		t.Fatalf("RecordDeliveryAttempt: %v", err)
+	}
+	if _, err := db.RecordDeliveryAttempt(deliveries[1].ID, AttemptResult{StatusCode: 500}, 20); err != nil {
+		t.Fatalf("RecordDeliveryAttempt: %v", err)
+	}
+
+	pruned, err := db.PruneOutboundDeliveries(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("PruneOutboundDeliveries: %v", err)
+	}
+	if pruned != 2 {
+		t.Errorf("pruned %d deliveries, want 2", pruned)
+	}
+	remaining, _, _, err := db.GetDueDeliveries(time.Now(), 10, func(OutboundDelivery) bool { return false })
+	if err != nil {
+		t.Fatalf("GetDueDeliveries: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].ID != deliveries[2].ID {
+		t.Errorf("remaining = %+v, want the pending delivery", remaining)
+	}
+}
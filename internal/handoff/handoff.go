@@ -0,0 +1,202 @@
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Environment variables naming the descriptors a new process inherits.
+const (
+	listenerEnv = "CHIRPY_LISTENER_FD"
+	readyEnv    = "CHIRPY_READY_FD"
+)
+
+// Bytes the new process writes to the ready pipe.
+const (
+	startedByte = 'S'
+	readyByte   = 'R'
+)
+
+var (
+	// ErrNotStarted is returned by Start when the new process exits or
+	// times out before it has started.
+	ErrNotStarted = errors.New("new process didn't start")
+	// ErrNotReady is returned by Process.Wait when the new process exits or
+	// times out before it is ready.
+	ErrNotReady = errors.New("new process didn't become ready")
+)
+
+// readyPipe is the pipe to the previous process, kept open between Started
+// and Ready.
+var readyPipe *os.File
+
+// Inherited reports whether this process was started by Start to take over
+// from a previous one.
+func Inherited() bool {
+	return readyPipe != nil || os.Getenv(readyEnv) != ""
+}
+
+// Listen returns the listener handed over by the previous process, if there
+// was one, and otherwise listens on addr.
+func Listen(addr string) (net.Listener, error) {
+	fd := os.Getenv(listenerEnv)
+	if fd == "" {
+		return net.Listen("tcp", addr)
+	}
+	os.Unsetenv(listenerEnv)
+
+	f, err := inherited(fd, "listener")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// Started tells the previous process, if any, that this one has started
+// and is waiting to take over, so it can stop serving and release what the
+// two can't share, like the database.
+func Started() error {
+	return signalPrevious(startedByte)
+}
+
+// Ready tells the previous process, if any, that this one is serving.
+func Ready() error {
+	err := signalPrevious(readyByte)
+	if readyPipe != nil {
+		readyPipe.Close()
+		readyPipe = nil
+	}
+	return err
+}
+
+func signalPrevious(b byte) error {
+	if readyPipe == nil {
+		fd := os.Getenv(readyEnv)
+		if fd == "" {
+			return nil
+		}
+		os.Unsetenv(readyEnv)
+
+		f, err := inherited(fd, "ready pipe")
+		if err != nil {
+			return err
+		}
+		readyPipe = f
+	}
+	_, err := readyPipe.Write([]byte{b})
+	return err
+}
+
+// Process is a new process started by Start.
+type Process struct {
+	Pid   int
+	ready <-chan bool
+}
+
+// Wait waits up to timeout for the new process to call Ready.
+func (p *Process) Wait(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ok := <-p.ready:
+		if ok {
+			return nil
+		}
+	case <-timer.C:
+	}
+	return ErrNotReady
+}
+
+// Start runs a new copy of the current binary, with the same arguments and
+// environment, that inherits ln. It returns once the new process has called
+// Started, after which the caller should stop accepting connections and
+// shut down; Process.Wait then tells whether the new process took over. If
+// the new process fails to start, exits or doesn't call Started within
+// timeout it is killed and the caller keeps serving.
+func Start(ln net.Listener, timeout time.Duration) (*Process, error) {
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("can't hand over a %T", ln)
+	}
+	lnFile, err := filer.File()
+	if err != nil {
+		return nil, err
+	}
+	defer lnFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return nil, err
+	}
+
+	// ExtraFiles start at descriptor 3
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{lnFile, readyW}
+	cmd.Env = append(os.Environ(), listenerEnv+"=3", readyEnv+"=4")
+	err = cmd.Start()
+	// Only the new process may hold the write end, so a crash reads as EOF
+	readyW.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// A process that skips Started is ready as soon as it's started
+	started := make(chan bool, 1)
+	ready := make(chan bool, 1)
+	go func() {
+		defer readyR.Close()
+		var b [1]byte
+		for first := true; ; first = false {
+			n, _ := readyR.Read(b[:])
+			if first {
+				started <- n == 1
+			}
+			if n == 0 {
+				ready <- false
+				return
+			}
+			if b[0] == readyByte {
+				ready <- true
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ok := <-started:
+		if ok {
+			// Reap the new process if it exits before this one does
+			go cmd.Wait()
+			return &Process{Pid: cmd.Process.Pid, ready: ready}, nil
+		}
+	case <-timer.C:
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	return nil, ErrNotStarted
+}
+
+func inherited(fd, name string) (*os.File, error) {
+	n, err := strconv.Atoi(fd)
+	if err != nil || n < 3 {
+		return nil, fmt.Errorf("invalid inherited %s descriptor %q", name, fd)
+	}
+	return os.NewFile(uintptr(n), name), nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package handoff
+
+import "os"
+
+// Signal is nil where handing over listeners isn't supported.
+var Signal os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package handoff
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signal asks a running server to hand its listener over to a new process.
+var Signal os.Signal = syscall.SIGUSR2
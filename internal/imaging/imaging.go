@@ -0,0 +1,177 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder with image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Format is an image format uploads may use.
+type Format string
+
+const (
+	PNG  Format = "png"
+	JPEG Format = "jpeg"
+	GIF  Format = "gif"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooLarge          = errors.New("image dimensions are too large")
+)
+
+// magicNumbers identify formats by their leading bytes. The uploader's
+// filename and Content-Type are never trusted.
+var magicNumbers = []struct {
+	prefix string
+	format Format
+}{
+	{"\x89PNG\r\n\x1a\n", PNG},
+	{"\xff\xd8\xff", JPEG},
+	{"GIF87a", GIF},
+	{"GIF89a", GIF},
+}
+
+// Sniff returns the format of the image data from its magic bytes.
+func Sniff(data []byte) (Format, error) {
+	for _, m := range magicNumbers {
+		if bytes.HasPrefix(data, []byte(m.prefix)) {
+			return m.format, nil
+		}
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Decode checks the format and dimensions of the data before decoding it, so
+// a small file can't make us allocate a huge image. Only the first frame of
+// animated GIFs is kept.
+func Decode(data []byte, maxPixels int) (*image.NRGBA, Format, error) {
+	format, err := Sniff(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	if name != string(format) {
+		return nil, "", ErrUnsupportedFormat
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+
+	return toNRGBA(img), format, nil
+}
+
+// Encode writes the image in the given format. GIFs are written as PNG, so
+// the format actually used is returned.
+func Encode(w io.Writer, img image.Image, format Format) (Format, error) {
+	switch format {
+	case JPEG:
+		return JPEG, jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	default:
+		return PNG, png.Encode(w, img)
+	}
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+// Extension returns the file extension of the format, without the dot.
+func (f Format) Extension() string {
+	if f == JPEG {
+		return "jpg"
+	}
+	return string(f)
+}
+
+// Fit scales the image down so neither side is longer than maxSize, keeping
+// its aspect ratio. Smaller images are returned unchanged.
+func Fit(img *image.NRGBA, maxSize int) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= maxSize && h <= maxSize {
+		return img
+	}
+	if w >= h {
+		return resize(img, maxSize, max(1, h*maxSize/w))
+	}
+	return resize(img, max(1, w*maxSize/h), maxSize)
+}
+
+// Square crops the image to a centered square and scales it to size.
+func Square(img *image.NRGBA, size int) *image.NRGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	cropped := toNRGBA(img.SubImage(image.Rect(x0, y0, x0+side, y0+side)))
+	if side <= size {
+		return cropped
+	}
+	return resize(cropped, size, size)
+}
+
+// resize scales the image down by averaging the source pixels each
+// destination pixel covers.
+func resize(src *image.NRGBA, width, height int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		sy0 := y * sh / height
+		sy1 := max(sy0+1, (y+1)*sh/height)
+		for x := 0; x < width; x++ {
+			sx0 := x * sw / width
+			sx1 := max(sx0+1, (x+1)*sw/width)
+
+			// Colors are weighted by alpha so transparent pixels don't
+			// darken the edges
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					pa := uint64(p[3])
+					r += uint64(p[0]) * pa
+					g += uint64(p[1]) * pa
+					b += uint64(p[2]) * pa
+					a += pa
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+			if a > 0 {
+				d[0] = uint8(r / a)
+				d[1] = uint8(g / a)
+				d[2] = uint8(b / a)
+			}
+			d[3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// toNRGBA copies the image into an NRGBA image whose bounds start at 0,0.
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,233 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrUnknownKey = errors.New("unknown or retired signing key")
+
+// manifestFile lists the keys in a key directory and names the one used for
+// signing:
+//
+//	{
+//	  "signing_key": "2024-06",
+//	  "keys": [
+//	    {"kid": "2024-06", "file": "2024-06.pem"},
+//	    {"kid": "2024-01", "file": "2024-01.pem", "retired": true}
+//	  ]
+//	}
+//
+// Keys that are not retired are still accepted for validation and published
+// in the JWKS, so tokens signed before a rotation keep working until they
+// expire. Retired keys are ignored entirely.
+const manifestFile = "keys.json"
+
+type manifest struct {
+	SigningKey string `json:"signing_key"`
+	Keys       []struct {
+		KID     string `json:"kid"`
+		File    string `json:"file"`
+		Retired bool   `json:"retired"`
+	} `json:"keys"`
+}
+
+// Key is one signing key with the algorithm it is used with.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	signer crypto.PrivateKey
+	public crypto.PublicKey
+	// published keys appear in the JWKS; shared secrets never do.
+	published bool
+}
+
+// KeyRing holds the keys tokens are signed and validated with.
+type KeyRing struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// JWK is the public half of a key as published in a JWK Set (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// Load reads the manifest and every non-retired PEM private key from dir.
+// RSA keys sign with RS256 and Ed25519 keys with EdDSA.
+func Load(dir string) (*KeyRing, error) {
+	dat, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	m := manifest{}
+	err = json.Unmarshal(dat, &m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", manifestFile, err)
+	}
+
+	ring := &KeyRing{keys: map[string]*Key{}}
+	for _, entry := range m.Keys {
+		if entry.Retired {
+			continue
+		}
+		if entry.KID == "" {
+			return nil, fmt.Errorf("%s: key without kid", manifestFile)
+		}
+		if _, ok := ring.keys[entry.KID]; ok {
+			return nil, fmt.Errorf("%s: duplicate kid %q", manifestFile, entry.KID)
+		}
+		key, err := loadKey(filepath.Join(dir, entry.File))
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", entry.KID, err)
+		}
+		key.ID = entry.KID
+		ring.keys[entry.KID] = key
+	}
+
+	signing, ok := ring.keys[m.SigningKey]
+	if !ok {
+		return nil, fmt.Errorf("%s: signing key %q is missing or retired", manifestFile, m.SigningKey)
+	}
+	ring.signing = signing
+
+	return ring, nil
+}
+
+// NewHMAC returns a key ring with a single HS256 shared secret. It is meant for
+// development setups that have no key directory.
+func NewHMAC(secret string) *KeyRing {
+	key := &Key{
+		ID:     "hs256",
+		Method: jwt.SigningMethodHS256,
+		signer: []byte(secret),
+		public: []byte(secret),
+	}
+	return &KeyRing{
+		signing: key,
+		keys:    map[string]*Key{key.ID: key},
+	}
+}
+
+// Sign signs the claims with the current signing key and sets the kid header.
+func (ring *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ring.signing.Method, claims)
+	token.Header["kid"] = ring.signing.ID
+	return token.SignedString(ring.signing.signer)
+}
+
+// Keyfunc resolves the validation key from the token's kid header. It rejects
+// tokens whose algorithm doesn't match the key's, so an RSA public key can
+// never be used as an HMAC secret.
+func (ring *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ring.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), kid)
+	}
+	return key.public, nil
+}
+
+// Algorithms returns the algorithms of the keys in the ring, for pinning the
+// methods a parser accepts.
+func (ring *KeyRing) Algorithms() []string {
+	algs := []string{}
+	for _, key := range ring.keys {
+		if !slices.Contains(algs, key.Method.Alg()) {
+			algs = append(algs, key.Method.Alg())
+		}
+	}
+	return algs
+}
+
+// JWKS returns the public keys of the ring.
+func (ring *KeyRing) JWKS() []JWK {
+	jwks := []JWK{}
+	for _, key := range ring.keys {
+		if !key.published {
+			continue
+		}
+		jwk := JWK{
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: key.Method.Alg(),
+		}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		jwks = append(jwks, jwk)
+	}
+	sort.Slice(jwks, func(i, j int) bool {
+		return jwks[i].KeyID < jwks[j].KeyID
+	})
+	return jwks
+}
+
+func loadKey(path string) (*Key, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return &Key{Method: jwt.SigningMethodRS256, signer: private, public: &private.PublicKey, published: true}, nil
+	case ed25519.PrivateKey:
+		return &Key{Method: jwt.SigningMethodEdDSA, signer: private, public: private.Public(), published: true}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+}
@@ -0,0 +1,268 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testKeys are generated once, since RSA keys are slow to make.
+var testKeys = struct {
+	rsa      *rsa.PrivateKey
+	ed       ed25519.PrivateKey
+	retired  ed25519.PrivateKey
+	smallRSA *rsa.PrivateKey
+}{}
+
+func TestMain(m *testing.M) {
+	var err error
+	if testKeys.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	if testKeys.smallRSA, err = rsa.GenerateKey(rand.Reader, 1024); err != nil {
+		panic(err)
+	}
+	if _, testKeys.ed, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		panic(err)
+	}
+	if _, testKeys.retired, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func writeKey(t *testing.T, dir, name string, key any) {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	dat := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), dat, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func writeManifest(t *testing.T, dir, manifest string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, manifestFile), []byte(manifest), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+// loadTestRing signs with the Ed25519 key "2024-06", still accepts the RSA
+// key "2024-01" and has retired "2023-06".
+func loadTestRing(t *testing.T) *KeyRing {
+	t.Helper()
+	dir := t.TempDir()
+	writeKey(t, dir, "2024-06.pem", testKeys.ed)
+	writeKey(t, dir, "2024-01.pem", testKeys.rsa)
+	writeKey(t, dir, "2023-06.pem", testKeys.retired)
+	writeManifest(t, dir, `{
+		"signing_key": "2024-06",
+		"keys": [
+			{"kid": "2024-06", "file": "2024-06.pem"},
+			{"kid": "2024-01", "file": "2024-01.pem"},
+			{"kid": "2023-06", "file": "2023-06.pem", "retired": true}
+		]
+	}`)
+	ring, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return ring
+}
+
+func testClaims() jwt.Claims {
+	return jwt.RegisteredClaims{
+		Subject:   "1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+// signWith signs a token as if by another key ring.
+func signWith(t *testing.T, method jwt.SigningMethod, kid string, key any) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, testClaims())
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func parse(ring *KeyRing, tokenString string) error {
+	_, err := jwt.Parse(tokenString, ring.Keyfunc, jwt.WithValidMethods(ring.Algorithms()))
+	return err
+}
+
+func TestSignUsesSigningKey(t *testing.T) {
+	ring := loadTestRing(t)
+	signed, err := ring.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	token, err := jwt.Parse(signed, ring.Keyfunc, jwt.WithValidMethods(ring.Algorithms()))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if token.Header["kid"] != "2024-06" || token.Method.Alg() != "EdDSA" {
+		t.Errorf("kid %v, alg %s; want 2024-06, EdDSA", token.Header["kid"], token.Method.Alg())
+	}
+}
+
+func TestKeyfuncSelectsKeyByKID(t *testing.T) {
+	ring := loadTestRing(t)
+
+	// Tokens signed before a rotation keep validating
+	if err := parse(ring, signWith(t, jwt.SigningMethodRS256, "2024-01", testKeys.rsa)); err != nil {
+		t.Errorf("token from the previous key: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"retired key", signWith(t, jwt.SigningMethodEdDSA, "2023-06", testKeys.retired)},
+		{"unknown kid", signWith(t, jwt.SigningMethodEdDSA, "2025-01", testKeys.ed)},
+		{"no kid", signWith(t, jwt.SigningMethodEdDSA, "", testKeys.ed)},
+	}
+	for _, tt := range tests {
+		if err := parse(ring, tt.token); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, ErrUnknownKey)
+		}
+	}
+
+	// A known kid doesn't help a token signed by another key
+	if err := parse(ring, signWith(t, jwt.SigningMethodEdDSA, "2024-06", testKeys.retired)); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		t.Errorf("wrong key for kid: err = %v, want %v", err, jwt.ErrTokenSignatureInvalid)
+	}
+}
+
+func TestKeyfuncPinsAlgorithm(t *testing.T) {
+	ring := loadTestRing(t)
+
+	// The classic confusion attack: an HMAC token keyed with the RSA public
+	// key, which anyone can fetch from the JWKS
+	publicDER, err := x509.MarshalPKIXPublicKey(&testKeys.rsa.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	forged := signWith(t, jwt.SigningMethodHS256, "2024-01", publicPEM)
+
+	token, _, err := jwt.NewParser().ParseUnverified(forged, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	if _, err := ring.Keyfunc(token); err == nil || !strings.Contains(err.Error(), "unexpected signing method") {
+		t.Errorf("Keyfunc err = %v, want an unexpected signing method", err)
+	}
+	if err := parse(ring, forged); err == nil {
+		t.Error("forged HS256 token accepted")
+	}
+
+	// Both asymmetric algorithms are in the ring, but each key only
+	// validates its own
+	if err := parse(ring, signWith(t, jwt.SigningMethodRS256, "2024-06", testKeys.rsa)); err == nil {
+		t.Error("RS256 token accepted for an Ed25519 kid")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	jwks := loadTestRing(t).JWKS()
+	if len(jwks) != 2 {
+		t.Fatalf("JWKS = %+v, want the two keys that aren't retired", jwks)
+	}
+	rsaJWK, edJWK := jwks[0], jwks[1]
+	if rsaJWK.KeyID != "2024-01" || rsaJWK.KeyType != "RSA" || rsaJWK.Algorithm != "RS256" || rsaJWK.N == "" || rsaJWK.E != "AQAB" {
+		t.Errorf("RSA key = %+v", rsaJWK)
+	}
+	if edJWK.KeyID != "2024-06" || edJWK.KeyType != "OKP" || edJWK.Curve != "Ed25519" || edJWK.Algorithm != "EdDSA" || edJWK.X == "" {
+		t.Errorf("Ed25519 key = %+v", edJWK)
+	}
+
+	if jwks := NewHMAC("secret").JWKS(); len(jwks) != 0 {
+		t.Errorf("HMAC JWKS = %+v, want no keys", jwks)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	ring := NewHMAC("secret")
+	signed, err := ring.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := parse(ring, signed); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+	if err := parse(ring, signWith(t, jwt.SigningMethodHS256, "hs256", []byte("other secret"))); err == nil {
+		t.Error("token signed with another secret accepted")
+	}
+	if algs := ring.Algorithms(); len(algs) != 1 || algs[0] != "HS256" {
+		t.Errorf("Algorithms = %v, want HS256", algs)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     map[string]any
+		manifest string
+		want     string
+	}{
+		{
+			name:     "retired signing key",
+			keys:     map[string]any{"a.pem": testKeys.ed},
+			manifest: `{"signing_key": "a", "keys": [{"kid": "a", "file": "a.pem", "retired": true}]}`,
+			want:     `signing key "a" is missing or retired`,
+		},
+		{
+			name:     "duplicate kid",
+			keys:     map[string]any{"a.pem": testKeys.ed},
+			manifest: `{"signing_key": "a", "keys": [{"kid": "a", "file": "a.pem"}, {"kid": "a", "file": "a.pem"}]}`,
+			want:     `duplicate kid "a"`,
+		},
+		{
+			name:     "missing kid",
+			keys:     map[string]any{"a.pem": testKeys.ed},
+			manifest: `{"signing_key": "a", "keys": [{"file": "a.pem"}]}`,
+			want:     "key without kid",
+		},
+		{
+			name:     "small RSA key",
+			keys:     map[string]any{"a.pem": testKeys.smallRSA},
+			manifest: `{"signing_key": "a", "keys": [{"kid": "a", "file": "a.pem"}]}`,
+			want:     "at least 2048 bits",
+		},
+		{
+			name:     "missing key file",
+			manifest: `{"signing_key": "a", "keys": [{"kid": "a", "file": "a.pem"}]}`,
+			want:     `key "a"`,
+		},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for name, key := range tt.keys {
+			writeKey(t, dir, name, key)
+		}
+		writeManifest(t, dir, tt.manifest)
+		if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
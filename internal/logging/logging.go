@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secrets in log output.
+const Redacted = "REDACTED"
+
+// sensitiveKeys are substrings of attribute keys whose values are never
+// logged.
+var sensitiveKeys = []string{"authorization", "cookie", "password", "secret", "token", "api_key", "apikey"}
+
+// secretPatterns match secrets inside otherwise harmless strings, like a
+// URL's query or an error message. The first group, if any, is kept.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(\b(?:Bearer|ApiKey)\s+)[^\s,"]+`),
+	regexp.MustCompile(`(?i)((?:^|[?&\s])(?:access_token|refresh_token|token|api_key|secret)=)[^&\s"]*`),
+	regexp.MustCompile(`\beyJ[\w-]+\.[\w-]+\.[\w-]*`),
+	regexp.MustCompile(`\b(?:chirpy_pat|whsec)_[\w-]+`),
+}
+
+// New returns a logger writing to w in format, "text" or "json", that drops
+// records below level and redacts secrets.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redactAttr}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// RedactString removes anything that looks like a secret from s.
+func RedactString(s string) string {
+	for _, pattern := range secretPatterns {
+		if pattern.NumSubexp() > 0 {
+			s = pattern.ReplaceAllString(s, "${1}"+Redacted)
+		} else {
+			s = pattern.ReplaceAllString(s, Redacted)
+		}
+	}
+	return s
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return slog.String(a.Key, Redacted)
+		}
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, RedactString(a.Value.String()))
+	case slog.KindAny:
+		// Errors and other values are logged by their text anyway
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, RedactString(err.Error()))
+		}
+		if s, ok := a.Value.Any().(fmt.Stringer); ok {
+			return slog.String(a.Key, RedactString(s.String()))
+		}
+	}
+	return a
+}
@@ -0,0 +1,288 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the Prometheus text format WriteText
+// writes.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets suit latencies of a few milliseconds to a few seconds.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metric families and writes them in the Prometheus text
+// format. It and the metrics it holds are safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+// family is a metric with all its label combinations.
+type family interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteText writes every metric in the order they were registered.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// vec holds the children of a family by their label values.
+type vec[T any] struct {
+	name       string
+	help       string
+	labelNames []string
+	newChild   func() T
+
+	mu       sync.RWMutex
+	children map[string]T
+	values   map[string][]string
+}
+
+func newVec[T any](name, help string, labelNames []string, newChild func() T) *vec[T] {
+	return &vec[T]{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		newChild:   newChild,
+		children:   map[string]T{},
+		values:     map[string][]string{},
+	}
+}
+
+func (v *vec[T]) with(labelValues []string) T {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child
+	}
+	child = v.newChild()
+	v.children[key] = child
+	v.values[key] = slices.Clone(labelValues)
+	return child
+}
+
+// each calls fn for every child, sorted by label values so the output is
+// stable.
+func (v *vec[T]) each(fn func(labelValues []string, child T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]T, len(keys))
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		children[i] = v.children[key]
+		values[i] = v.values[key]
+	}
+	v.mu.RUnlock()
+
+	for i := range keys {
+		fn(values[i], children[i])
+	}
+}
+
+func (v *vec[T]) writeHeader(w *bufio.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, metricType)
+}
+
+// Counter is a count that only goes up.
+type Counter struct {
+	n atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.n.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.n.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.n.Load()
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	v *vec[*Counter]
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{v: newVec(name, help, labelNames, func() *Counter { return &Counter{} })}
+	r.register(name, c)
+	return c
+}
+
+// With returns the counter for the label values, in the order the label
+// names were registered.
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.v.with(labelValues)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.v.writeHeader(w, "counter")
+	c.v.each(func(labelValues []string, counter *Counter) {
+		fmt.Fprintf(w, "%s%s %d\n", c.v.name, formatLabels(c.v.labelNames, labelValues, "", ""), counter.Value())
+	})
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	upperBounds []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	v *vec[*Histogram]
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds,
+// which must be sorted, and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	upperBounds := slices.Clone(buckets)
+	h := &HistogramVec{v: newVec(name, help, labelNames, func() *Histogram {
+		return &Histogram{upperBounds: upperBounds, counts: make([]uint64, len(upperBounds))}
+	})}
+	r.register(name, h)
+	return h
+}
+
+// With returns the histogram for the label values, in the order the label
+// names were registered.
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.v.with(labelValues)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.v.writeHeader(w, "histogram")
+	h.v.each(func(labelValues []string, hist *Histogram) {
+		hist.mu.Lock()
+		counts := slices.Clone(hist.counts)
+		count, sum := hist.count, hist.sum
+		hist.mu.Unlock()
+
+		// Buckets are cumulative
+		var cumulative uint64
+		for i, upperBound := range hist.upperBounds {
+			cumulative += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.v.name, formatLabels(h.v.labelNames, labelValues, "le", formatFloat(upperBound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.v.name, formatLabels(h.v.labelNames, labelValues, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.v.name, formatLabels(h.v.labelNames, labelValues, "", ""), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.v.name, formatLabels(h.v.labelNames, labelValues, "", ""), count)
+	})
+}
+
+// formatLabels formats the labels, plus extraName if it isn't empty.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, escapeLabel(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
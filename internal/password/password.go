@@ -0,0 +1,173 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMismatch      = errors.New("password does not match")
+	ErrUnknownFormat = errors.New("unknown password hash format")
+	ErrInvalidParams = errors.New("invalid argon2id parameters")
+)
+
+// Params are the argon2id cost parameters. Memory is in KiB.
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Validate reports ErrInvalidParams for parameters argon2 can't hash with.
+func (p Params) Validate() error {
+	if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 || p.SaltLength == 0 || p.KeyLength == 0 {
+		return ErrInvalidParams
+	}
+	return nil
+}
+
+// DefaultParams follow the RFC 9106 second recommended option.
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Hasher hashes passwords with argon2id. Hashes are stored in the PHC string
+// format, e.g. "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>", so each one
+// records the parameters it was made with. bcrypt hashes from before argon2id
+// are still verified.
+type Hasher struct {
+	Params Params
+	// MaxConcurrent caps how many hashes are computed at once, since each
+	// takes Params.Memory KiB. Callers over the cap wait. Zero means no cap.
+	MaxConcurrent int
+
+	slotsOnce sync.Once
+	slots     chan struct{}
+}
+
+// acquire waits for a hashing slot and returns the func that frees it.
+func (h *Hasher) acquire() func() {
+	h.slotsOnce.Do(func() {
+		if h.MaxConcurrent > 0 {
+			h.slots = make(chan struct{}, h.MaxConcurrent)
+		}
+	})
+	if h.slots == nil {
+		return func() {}
+	}
+	h.slots <- struct{}{}
+	return func() { <-h.slots }
+}
+
+// Hash returns the encoded argon2id hash of password.
+func (h *Hasher) Hash(password string) (string, error) {
+	if err := h.Params.Validate(); err != nil {
+		return "", err
+	}
+
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	release := h.acquire()
+	key := argon2.IDKey([]byte(password), salt, h.Params.Iterations, h.Params.Memory, h.Params.Parallelism, h.Params.KeyLength)
+	release()
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Params.Memory, h.Params.Iterations, h.Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks password against an encoded hash. It returns ErrMismatch if
+// the password is wrong.
+func (h *Hasher) Verify(password, encodedHash string) error {
+	if isBcrypt(encodedHash) {
+		release := h.acquire()
+		err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+		release()
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	}
+
+	params, salt, key, err := decode(encodedHash)
+	if err != nil {
+		return err
+	}
+
+	release := h.acquire()
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	release()
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether the hash was made with another algorithm or
+// other parameters than the Hasher's current ones.
+func (h *Hasher) NeedsRehash(encodedHash string) bool {
+	params, salt, _, err := decode(encodedHash)
+	if err != nil {
+		return true
+	}
+	params.SaltLength = uint32(len(salt))
+	return params != h.Params
+}
+
+func isBcrypt(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") ||
+		strings.HasPrefix(encodedHash, "$2b$") ||
+		strings.HasPrefix(encodedHash, "$2y$")
+}
+
+func decode(encodedHash string) (Params, []byte, []byte, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	params := Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	if params.Validate() != nil {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,83 @@
+package password
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testParams = Params{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestHashVerify(t *testing.T) {
+	h := &Hasher{Params: testParams}
+
+	hash, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if err := h.Verify("correct horse", hash); err != nil {
+		t.Errorf("Verify with the right password: %v", err)
+	}
+	if err := h.Verify("wrong horse", hash); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify with a wrong password: err = %v, want ErrMismatch", err)
+	}
+	if h.NeedsRehash(hash) {
+		t.Error("NeedsRehash = true for a hash with the current params")
+	}
+}
+
+func TestHashRejectsInvalidParams(t *testing.T) {
+	params := testParams
+	params.Parallelism = 0
+	h := &Hasher{Params: params}
+
+	if _, err := h.Hash("password"); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Hash with zero parallelism: err = %v, want ErrInvalidParams", err)
+	}
+}
+
+func TestVerifyRejectsZeroParallelismHash(t *testing.T) {
+	h := &Hasher{Params: testParams}
+	hash := "$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+
+	if err := h.Verify("password", hash); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Verify: err = %v, want ErrUnknownFormat", err)
+	}
+}
+
+func TestHasherMaxConcurrent(t *testing.T) {
+	h := &Hasher{Params: testParams, MaxConcurrent: 2}
+
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := h.acquire()
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+			release()
+		}()
+	}
+	wg.Wait()
+
+	if got := peak.Load(); got > 2 {
+		t.Errorf("%d hashes ran at once, want at most 2", got)
+	}
+}
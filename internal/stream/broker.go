@@ -0,0 +1,156 @@
+package stream
+
+import (
+	"sync"
+)
+
+// Event is a published event. IDs increase by one with every event, so a
+// subscriber that knows the last ID it saw can tell whether it missed any.
+type Event struct {
+	ID       uint64
+	Type     string
+	AuthorID int
+	Data     []byte
+}
+
+// Broker fans events out to subscribers and keeps the latest ones so
+// reconnecting subscribers can catch up. Publishing never blocks: a
+// subscriber whose buffer is full is dropped instead, and can resume from the
+// replay buffer once it reconnects.
+type Broker struct {
+	mu          sync.Mutex
+	firstID     uint64
+	lastID      uint64
+	replay      []Event
+	replaySize  int
+	bufferSize  int
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewBroker returns a broker that keeps the last replaySize events and
+// buffers up to bufferSize events per subscriber. Event IDs start after
+// firstID, which should differ between runs so IDs from a previous run
+// aren't mistaken for current ones.
+func NewBroker(firstID uint64, replaySize, bufferSize int) *Broker {
+	return &Broker{
+		firstID:     firstID,
+		lastID:      firstID,
+		replaySize:  replaySize,
+		bufferSize:  bufferSize,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription receives the events matching its filter on C. C is closed
+// when the subscriber falls behind, is unsubscribed or the broker is closed.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	filter func(Event) bool
+	// StartID is the ID of the latest event when the subscription was made.
+	// Every later event matching the filter is sent on C.
+	StartID uint64
+	// Lagged is set when the subscription was dropped for falling behind.
+	// It may only be read after C is closed.
+	Lagged bool
+}
+
+// Publish assigns the event the next ID and delivers it to every matching
+// subscriber.
+func (b *Broker) Publish(eventType string, authorID int, data []byte) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, AuthorID: authorID, Data: data}
+	if b.closed {
+		return event
+	}
+
+	b.replay = append(b.replay, event)
+	if len(b.replay) > b.replaySize {
+		b.replay = b.replay[len(b.replay)-b.replaySize:]
+	}
+
+	for sub := range b.subscribers {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+			sub.Lagged = true
+			b.remove(sub)
+		}
+	}
+
+	return event
+}
+
+// Subscribe registers a subscriber for events matching filter, or every
+// event when filter is nil. When resume is set, the buffered events after
+// lastEventID are returned to be sent before those on C; complete is false
+// if some of them are no longer buffered, or lastEventID isn't one the
+// broker handed out.
+func (b *Broker) Subscribe(filter func(Event) bool, resume bool, lastEventID uint64) (sub *Subscription, missed []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan Event, b.bufferSize)
+	sub = &Subscription{C: c, c: c, filter: filter, StartID: b.lastID}
+	if b.closed {
+		close(c)
+		return sub, nil, true
+	}
+	b.subscribers[sub] = struct{}{}
+
+	if !resume {
+		return sub, nil, true
+	}
+
+	oldest := b.lastID + 1
+	if len(b.replay) > 0 {
+		oldest = b.replay[0].ID
+	}
+	complete = lastEventID >= b.firstID && lastEventID <= b.lastID && lastEventID+1 >= oldest
+	for _, event := range b.replay {
+		if event.ID <= lastEventID {
+			continue
+		}
+		if filter == nil || filter(event) {
+			missed = append(missed, event)
+		}
+	}
+
+	return sub, missed, complete
+}
+
+// Unsubscribe stops delivering events to sub and closes its channel.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(sub)
+}
+
+// Close closes every subscription. Later subscriptions are closed straight
+// away and later events are dropped.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subscribers {
+		b.remove(sub)
+	}
+}
+
+// remove must be called with b.mu held.
+func (b *Broker) remove(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; !ok {
+		return
+	}
+	delete(b.subscribers, sub)
+	close(sub.c)
+}
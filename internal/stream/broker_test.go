@@ -0,0 +1,131 @@
+package stream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayWindow(t *testing.T) {
+	b := NewBroker(100, 3, 8)
+	for i := 0; i < 5; i++ {
+		b.Publish("chirp.created", 1, nil)
+	}
+	// Events 101 to 105 were published; 103 to 105 are still buffered
+
+	tests := []struct {
+		name        string
+		lastEventID uint64
+		wantMissed  []uint64
+		complete    bool
+	}{
+		{"up to date", 105, nil, true},
+		{"within the buffer", 103, []uint64{104, 105}, true},
+		{"just before the buffer", 102, []uint64{103, 104, 105}, true},
+		{"older than the buffer", 101, []uint64{103, 104, 105}, false},
+		{"from a previous run", 7, []uint64{103, 104, 105}, false},
+		{"from the future", 200, nil, false},
+	}
+	for _, tt := range tests {
+		sub, missed, complete := b.Subscribe(nil, true, tt.lastEventID)
+		b.Unsubscribe(sub)
+		var ids []uint64
+		for _, event := range missed {
+			ids = append(ids, event.ID)
+		}
+		if complete != tt.complete || !equalIDs(ids, tt.wantMissed) {
+			t.Errorf("%s: missed %v, complete %v; want %v, %v", tt.name, ids, complete, tt.wantMissed, tt.complete)
+		}
+	}
+}
+
+func TestSubscribeFilter(t *testing.T) {
+	b := NewBroker(0, 10, 8)
+	b.Publish("chirp.created", 1, nil)
+	b.Publish("chirp.created", 2, nil)
+
+	byAuthor2 := func(event Event) bool { return event.AuthorID == 2 }
+	sub, missed, complete := b.Subscribe(byAuthor2, true, 0)
+	defer b.Unsubscribe(sub)
+	if !complete || len(missed) != 1 || missed[0].AuthorID != 2 {
+		t.Errorf("missed = %+v, complete %v; want the one event by author 2", missed, complete)
+	}
+	if sub.StartID != 2 {
+		t.Errorf("StartID = %d, want 2", sub.StartID)
+	}
+
+	b.Publish("chirp.created", 1, nil)
+	b.Publish("chirp.deleted", 2, nil)
+	select {
+	case event := <-sub.C:
+		if event.ID != 4 || event.AuthorID != 2 {
+			t.Errorf("received %+v, want event 4 by author 2", event)
+		}
+	default:
+		t.Fatal("no event received")
+	}
+	select {
+	case event := <-sub.C:
+		t.Errorf("received %+v, want nothing more", event)
+	default:
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := NewBroker(0, 10, 2)
+	slow, _, _ := b.Subscribe(nil, false, 0)
+	fast, _, _ := b.Subscribe(nil, false, 0)
+	defer b.Unsubscribe(fast)
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 3; i++ {
+			b.Publish("chirp.created", 1, nil)
+			<-fast.C
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+
+	var received int
+	for range slow.C {
+		received++
+	}
+	if received != 2 || !slow.Lagged {
+		t.Errorf("slow subscriber got %d events, lagged %v; want 2 events and dropped", received, slow.Lagged)
+	}
+	if fast.Lagged {
+		t.Error("fast subscriber was dropped")
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := NewBroker(0, 10, 2)
+	sub, _, _ := b.Subscribe(nil, false, 0)
+	b.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("subscription open after Close")
+	}
+	later, _, _ := b.Subscribe(nil, false, 0)
+	if _, ok := <-later.C; ok {
+		t.Error("subscription made after Close is open")
+	}
+	if sub.Lagged {
+		t.Error("closed subscription marked as lagged")
+	}
+}
+
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
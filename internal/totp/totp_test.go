@@ -0,0 +1,96 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 4226 and RFC 6238 test vectors
+var rfcSecret = []byte("12345678901234567890")
+
+func TestGenerateRFC4226(t *testing.T) {
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+	for counter, code := range want {
+		if got := generate(rfcSecret, int64(counter)); got != code {
+			t.Errorf("generate(counter %d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// The RFC 6238 SHA-1 vectors have 8 digits; with 6 digits the codes are
+// their last 6.
+func TestValidateRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	secret := encoding.EncodeToString(rfcSecret)
+	for _, tt := range tests {
+		at := time.Unix(tt.unix, 0)
+		code := tt.code[len(tt.code)-digits:]
+
+		step, ok := Validate(secret, code, at)
+		if !ok {
+			t.Errorf("Validate(%s at %d) failed", code, tt.unix)
+			continue
+		}
+		if want := tt.unix / period; step != want {
+			t.Errorf("Validate(%s at %d) step = %d, want %d", code, tt.unix, step, want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	secret := encoding.EncodeToString(rfcSecret)
+	at := time.Unix(1111111111, 0)
+	code := generate(rfcSecret, at.Unix()/period)
+
+	if _, ok := Validate(secret, code, at.Add(period*time.Second)); !ok {
+		t.Error("code from the previous step was rejected")
+	}
+	if _, ok := Validate(secret, code, at.Add(-period*time.Second)); !ok {
+		t.Error("code from the next step was rejected")
+	}
+	if _, ok := Validate(secret, code, at.Add(3*period*time.Second)); ok {
+		t.Error("code from three steps ago was accepted")
+	}
+}
+
+func TestValidateRejectsMalformed(t *testing.T) {
+	secret := encoding.EncodeToString(rfcSecret)
+	at := time.Unix(59, 0)
+
+	if _, ok := Validate(secret, "94287082", at); ok {
+		t.Error("8-digit code was accepted")
+	}
+	if _, ok := Validate("not base32!", "287082", at); ok {
+		t.Error("code was accepted with an invalid secret")
+	}
+	if _, ok := Validate(strings.ToLower(secret), "287082", at); !ok {
+		t.Error("lower-case secret was rejected")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("JBSWY3DPEHPK3PXP", "Chirpy", "user@example.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:user@example.com?") {
+		t.Errorf("URI = %s, want an otpauth://totp/Chirpy:user@example.com label", uri)
+	}
+	for _, param := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=Chirpy", "digits=6", "period=30"} {
+		if !strings.Contains(uri, param) {
+			t.Errorf("URI = %s, missing %s", uri, param)
+		}
+	}
+}
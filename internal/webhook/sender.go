@@ -0,0 +1,113 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var ErrForbiddenAddress = errors.New("webhook URL resolves to a private or loopback address")
+
+// Headers set on outgoing deliveries.
+const (
+	SignatureHeader = "Chirpy-Signature"
+	EventIDHeader   = "Chirpy-Event-ID"
+	EventTypeHeader = "Chirpy-Event-Type"
+)
+
+// Sender posts signed events to subscriber endpoints.
+type Sender struct {
+	Client    *http.Client
+	UserAgent string
+}
+
+// NewClient returns a client for delivering webhooks. Unless allowPrivate is
+// set it refuses to connect to loopback, private and link-local addresses, so
+// endpoints can't be pointed at our own network. The check is made on the
+// address actually dialed, which also covers redirects and DNS rebinding.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+				return ErrForbiddenAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// Redirects are not followed; the endpoint must answer itself
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// ValidateURL checks that an endpoint URL is absolute and uses HTTPS, or
+// plain HTTP when allowInsecure is set.
+func ValidateURL(endpoint string, allowInsecure bool) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return errors.New("invalid URL")
+	}
+	if u.User != nil {
+		return errors.New("URL must not contain credentials")
+	}
+	switch {
+	case u.Scheme == "https":
+		return nil
+	case u.Scheme == "http" && allowInsecure:
+		return nil
+	default:
+		return errors.New("URL must use https")
+	}
+}
+
+// Send posts the body to the endpoint, signed with secret. It returns the
+// response status code; any status outside 2xx is reported as an error.
+func (s *Sender) Send(ctx context.Context, endpoint string, secret []byte, eventID, eventType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(secret, time.Now(), body))
+	req.Header.Set(EventIDHeader, eventID)
+	req.Header.Set(EventTypeHeader, eventType)
+	if s.UserAgent != "" {
+		req.Header.Set("User-Agent", s.UserAgent)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	oldSecret = []byte("old-secret")
+	newSecret = []byte("new-secret")
+	body      = []byte(`{"event":"user.upgraded","data":{"user_id":3}}`)
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1718000000, 0)
+	v := &Verifier{Secrets: [][]byte{newSecret}}
+
+	verified, err := v.Verify(Sign(newSecret, now, body), body, now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !verified.Timestamp.Equal(now) {
+		t.Errorf("Timestamp = %v, want %v", verified.Timestamp, now)
+	}
+	if verified.ID == "" {
+		t.Error("ID is empty")
+	}
+
+	if _, err := v.Verify(Sign(newSecret, now, body), []byte(`{"event":"user.downgraded"}`), now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered body: err = %v, want %v", err, ErrInvalidSignature)
+	}
+	if _, err := v.Verify(Sign(oldSecret, now, body), body, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("unknown secret: err = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	now := time.Unix(1718000000, 0)
+	v := &Verifier{Secrets: [][]byte{newSecret}}
+
+	if _, err := v.Verify("", body, now); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("empty header: err = %v, want %v", err, ErrMissingSignature)
+	}
+	for _, header := range []string{
+		"garbage",
+		"t=1718000000",
+		"v1=00",
+		"t=soon,v1=00",
+		"t=1718000000,v1=not-hex",
+	} {
+		if _, err := v.Verify(header, body, now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Verify(%q): err = %v, want %v", header, err, ErrInvalidSignature)
+		}
+	}
+}
+
+func TestVerifyStale(t *testing.T) {
+	now := time.Unix(1718000000, 0)
+	v := &Verifier{Secrets: [][]byte{newSecret}, Tolerance: time.Minute}
+
+	if _, err := v.Verify(Sign(newSecret, now.Add(-59*time.Second), body), body, now); err != nil {
+		t.Errorf("within tolerance: %v", err)
+	}
+	if _, err := v.Verify(Sign(newSecret, now.Add(-2*time.Minute), body), body, now); !errors.Is(err, ErrStale) {
+		t.Errorf("too old: err = %v, want %v", err, ErrStale)
+	}
+	if _, err := v.Verify(Sign(newSecret, now.Add(2*time.Minute), body), body, now); !errors.Is(err, ErrStale) {
+		t.Errorf("in the future: err = %v, want %v", err, ErrStale)
+	}
+
+	v.Tolerance = 0
+	if _, err := v.Verify(Sign(newSecret, now.Add(-2*time.Minute), body), body, now); err != nil {
+		t.Errorf("within the default tolerance: %v", err)
+	}
+}
+
+func TestVerifyRotatingSecrets(t *testing.T) {
+	now := time.Unix(1718000000, 0)
+	v := &Verifier{Secrets: [][]byte{oldSecret, newSecret}}
+
+	signedOld := Sign(oldSecret, now, body)
+	signedNew := Sign(newSecret, now, body)
+	_, newSig, _ := strings.Cut(signedNew, ",")
+	signedBoth := signedOld + "," + newSig
+
+	var ids []string
+	for _, header := range []string{signedOld, signedNew, signedBoth} {
+		verified, err := v.Verify(header, body, now)
+		if err != nil {
+			t.Fatalf("Verify(%q): %v", header, err)
+		}
+		ids = append(ids, verified.ID)
+	}
+	// However the delivery was signed, it's the same delivery
+	if ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Errorf("IDs differ between signatures of one delivery: %v", ids)
+	}
+
+	later, err := v.Verify(Sign(newSecret, now.Add(time.Second), body), body, now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if later.ID == ids[0] {
+		t.Error("deliveries sent at different times have the same ID")
+	}
+}
@@ -0,0 +1,351 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The sample handshake from RFC 6455, section 1.3
+const (
+	sampleKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	sampleAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+func TestAcceptKey(t *testing.T) {
+	if got := acceptKey(sampleKey); got != sampleAccept {
+		t.Errorf("acceptKey = %s, want %s", got, sampleAccept)
+	}
+}
+
+func TestUpgradeRejectsBadHandshakes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := Upgrade(w, r); err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		{"not an upgrade", "GET", map[string]string{}, http.StatusBadRequest},
+		{"POST", "POST", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": sampleKey}, http.StatusBadRequest},
+		{"old version", "GET", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": sampleKey}, http.StatusUpgradeRequired},
+		{"short key", "GET", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, server.URL, nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+// testClient is the client side of a connection, writing masked frames as
+// browsers do.
+type testClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dial connects to a server whose handler upgrades the connection and
+// passes it to serve.
+func dial(t *testing.T, serve func(*Conn)) *testClient {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: chirpy.test\r\n"+
+		"Connection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+sampleKey+"\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != sampleAccept {
+		t.Fatalf("Sec-WebSocket-Accept = %s, want %s", got, sampleAccept)
+	}
+
+	return &testClient{conn: conn, br: br}
+}
+
+func (c *testClient) writeFrame(t *testing.T, fin bool, opcode int, payload []byte, masked bool) {
+	t.Helper()
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if masked {
+		mask := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+// readFrame reads an unmasked server frame.
+func (c *testClient) readFrame(t *testing.T) (opcode int, payload []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	if header[0]&0x80 == 0 {
+		t.Fatal("server sent a fragmented frame")
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server sent a masked frame")
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatalf("reading payload: %v", err)
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+func (c *testClient) expectClose(t *testing.T, code int) {
+	t.Helper()
+	opcode, payload := c.readFrame(t)
+	if opcode != opClose {
+		t.Fatalf("opcode = %d, want close", opcode)
+	}
+	if len(payload) < 2 {
+		t.Fatalf("close frame without a code")
+	}
+	if got := int(binary.BigEndian.Uint16(payload)); got != code {
+		t.Errorf("close code = %d (%s), want %d", got, payload[2:], code)
+	}
+}
+
+// echo sends back every message until ReadMessage fails, then reports the
+// error.
+func echo(errs chan<- error) func(*Conn) {
+	return func(conn *Conn) {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}
+}
+
+func TestEcho(t *testing.T) {
+	errs := make(chan error, 1)
+	client := dial(t, echo(errs))
+
+	long := []byte(strings.Repeat("chirp ", 100))
+	for _, tt := range []struct {
+		opcode  int
+		payload []byte
+	}{
+		{TextMessage, []byte("hello")},
+		{TextMessage, []byte{}},
+		{BinaryMessage, []byte{0, 1, 2, 255}},
+		{TextMessage, long},
+	} {
+		client.writeFrame(t, true, tt.opcode, tt.payload, true)
+		opcode, payload := client.readFrame(t)
+		if opcode != tt.opcode || string(payload) != string(tt.payload) {
+			t.Errorf("echo of %d/%q = %d/%q", tt.opcode, tt.payload, opcode, payload)
+		}
+	}
+
+	// Fragments are reassembled, with a ping answered in between
+	client.writeFrame(t, false, TextMessage, []byte("hel"), true)
+	client.writeFrame(t, true, opPing, []byte("are you there"), true)
+	client.writeFrame(t, true, opContinuation, []byte("lo"), true)
+	if opcode, payload := client.readFrame(t); opcode != opPong || string(payload) != "are you there" {
+		t.Errorf("reply to ping = %d/%q, want a pong with its payload", opcode, payload)
+	}
+	if opcode, payload := client.readFrame(t); opcode != TextMessage || string(payload) != "hello" {
+		t.Errorf("fragmented message = %d/%q, want hello", opcode, payload)
+	}
+
+	// Closing is acknowledged and reported with the client's code
+	closePayload := binary.BigEndian.AppendUint16(nil, CloseNormal)
+	client.writeFrame(t, true, opClose, append(closePayload, "bye"...), true)
+	client.expectClose(t, CloseNormal)
+	var closeErr *CloseError
+	if err := <-errs; !errors.As(err, &closeErr) || closeErr.Code != CloseNormal || closeErr.Reason != "bye" {
+		t.Errorf("ReadMessage err = %v, want close 1000 bye", err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(*testing.T, *testClient)
+		code  int
+		err   error
+	}{
+		{
+			name: "unmasked frame",
+			write: func(t *testing.T, c *testClient) {
+				c.writeFrame(t, true, TextMessage, []byte("hello"), false)
+			},
+			code: CloseProtocolError,
+			err:  ErrProtocol,
+		},
+		{
+			name: "unexpected continuation",
+			write: func(t *testing.T, c *testClient) {
+				c.writeFrame(t, true, opContinuation, []byte("lo"), true)
+			},
+			code: CloseProtocolError,
+			err:  ErrProtocol,
+		},
+		{
+			name: "new message inside a fragmented one",
+			write: func(t *testing.T, c *testClient) {
+				c.writeFrame(t, false, TextMessage, []byte("hel"), true)
+				c.writeFrame(t, true, TextMessage, []byte("lo"), true)
+			},
+			code: CloseProtocolError,
+			err:  ErrProtocol,
+		},
+		{
+			name: "fragmented ping",
+			write: func(t *testing.T, c *testClient) {
+				c.writeFrame(t, false, opPing, nil, true)
+			},
+			code: CloseProtocolError,
+			err:  ErrProtocol,
+		},
+		{
+			name: "unknown opcode",
+			write: func(t *testing.T, c *testClient) {
+				c.writeFrame(t, true, 3, nil, true)
+			},
+			code: CloseProtocolError,
+			err:  ErrProtocol,
+		},
+		{
+			name: "invalid UTF-8",
+			write: func(t *testing.T, c *testClient) {
+				c.writeFrame(t, true, TextMessage, []byte{0xff, 0xfe}, true)
+			},
+			code: CloseInvalidPayload,
+			err:  ErrProtocol,
+		},
+		{
+			name: "frame too big",
+			write: func(t *testing.T, c *testClient) {
+				c.writeFrame(t, true, BinaryMessage, make([]byte, 1<<16+1), true)
+			},
+			code: CloseMessageTooBig,
+			err:  ErrTooBig,
+		},
+		{
+			name: "fragments too big",
+			write: func(t *testing.T, c *testClient) {
+				c.writeFrame(t, false, BinaryMessage, make([]byte, 1<<15), true)
+				c.writeFrame(t, true, opContinuation, make([]byte, 1<<15+1), true)
+			},
+			code: CloseMessageTooBig,
+			err:  ErrTooBig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := make(chan error, 1)
+			client := dial(t, echo(errs))
+			tt.write(t, client)
+			client.expectClose(t, tt.code)
+			if err := <-errs; !errors.Is(err, tt.err) {
+				t.Errorf("ReadMessage err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNothingWrittenAfterClose(t *testing.T) {
+	errs := make(chan error, 1)
+	client := dial(t, func(conn *Conn) {
+		conn.WriteClose(CloseGoingAway, "server shutting down")
+		errs <- conn.WriteMessage(TextMessage, []byte("too late"))
+	})
+
+	client.expectClose(t, CloseGoingAway)
+	if err := <-errs; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("WriteMessage after WriteClose: err = %v, want %v", err, net.ErrClosed)
+	}
+}
@@ -2,13 +2,63 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 )
 
+var errTrailingData = errors.New("unexpected data after JSON value")
+
+// decodeJSON decodes the request body into dst. Bodies larger than the
+// configured limit, unknown fields and anything after the value are
+// rejected. Respond to errors with respondWithDecodeError.
+func (cfg *apiConfig) decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(cfg.config.MaxJSONBodyBytes)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return err
+		}
+		return errTrailingData
+	}
+	return nil
+}
+
+// respondWithDecodeError responds 413 to bodies that are too large and 400
+// to anything else decodeJSON rejects.
+func respondWithDecodeError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		respondWithBodyTooLarge(w, maxBytesErr.Limit)
+	case errors.Is(err, io.EOF):
+		respondWithError(w, http.StatusBadRequest, "Request body is required")
+	case errors.Is(err, errTrailingData):
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON: unexpected data after the object")
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %s has the wrong type", typeErr.Field))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for unknown fields
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON: "+strings.TrimPrefix(err.Error(), "json: "))
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+	}
+}
+
+func respondWithBodyTooLarge(w http.ResponseWriter, limit int64) {
+	respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body must be at most %d bytes", limit))
+}
+
 func respondWithError(w http.ResponseWriter, code int, msg string) {
 	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+		setResponseError(w, msg)
 	}
 	type errorResponse struct {
 		Error string `json:"error"`
@@ -22,7 +72,7 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		setResponseError(w, "Error marshalling JSON: "+err.Error())
 		w.WriteHeader(500)
 		return
 	}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginLimits is the throttling policy for one kind of key. After
+// backoffAfter failures each further failure locks the key for an
+// exponentially growing delay, capped at maxBackoff; at lockoutAfter failures
+// it is locked for lockout.
+type loginLimits struct {
+	backoffAfter int
+	lockoutAfter int
+	maxBackoff   time.Duration
+	lockout      time.Duration
+}
+
+var (
+	accountLoginLimits = loginLimits{
+		backoffAfter: 3,
+		lockoutAfter: 10,
+		maxBackoff:   15 * time.Minute,
+		lockout:      time.Hour,
+	}
+	// IPs are shared behind NATs, so they get more slack
+	ipLoginLimits = loginLimits{
+		backoffAfter: 20,
+		lockoutAfter: 100,
+		maxBackoff:   15 * time.Minute,
+		lockout:      time.Hour,
+	}
+
+	// Every reset request mails the account and replaces its previous link,
+	// so after a few each address gets one an hour
+	accountResetLimits = loginLimits{
+		backoffAfter: 3,
+		lockoutAfter: 3,
+		lockout:      time.Hour,
+	}
+	ipResetLimits = loginLimits{
+		backoffAfter: 20,
+		lockoutAfter: 20,
+		lockout:      time.Hour,
+	}
+)
+
+// loginFailureWindow is how long a failed login or a password reset request
+// counts against a key
+const loginFailureWindow = 24 * time.Hour
+
+func (l loginLimits) lockFor(failures int) time.Duration {
+	if failures >= l.lockoutAfter {
+		return l.lockout
+	}
+	if failures < l.backoffAfter {
+		return 0
+	}
+	backoff := time.Second * time.Duration(math.Pow(2, float64(failures-l.backoffAfter)))
+	return min(backoff, l.maxBackoff)
+}
+
+func accountThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(r *http.Request) string {
+	return "ip:" + clientInfo(r, "").IP
+}
+
+// Password reset requests are counted apart from failed logins
+func resetThrottleKey(key string) string {
+	return "reset:" + key
+}
+
+// checkLoginThrottle responds with 429 and reports false if the account or IP
+// is locked out
+func (cfg *apiConfig) checkLoginThrottle(w http.ResponseWriter, r *http.Request, email string) bool {
+	return cfg.checkThrottle(w, "Too many failed login attempts, try again later",
+		accountThrottleKey(email), ipThrottleKey(r))
+}
+
+// checkResetThrottle responds with 429 and reports false if too many
+// password resets were requested for the account or from the IP
+func (cfg *apiConfig) checkResetThrottle(w http.ResponseWriter, r *http.Request, email string) bool {
+	return cfg.checkThrottle(w, "Too many password reset requests, try again later",
+		resetThrottleKey(accountThrottleKey(email)), resetThrottleKey(ipThrottleKey(r)))
+}
+
+func (cfg *apiConfig) checkThrottle(w http.ResponseWriter, message string, keys ...string) bool {
+	lockedUntil, err := cfg.DB.GetLoginLockout(keys...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check attempts")
+		return false
+	}
+
+	wait := time.Until(lockedUntil)
+	if wait <= 0 {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, message)
+	return false
+}
+
+// recordLoginFailure counts a failed attempt against the account and the IP
+func (cfg *apiConfig) recordLoginFailure(r *http.Request, email string) {
+	_, err := cfg.DB.RecordLoginFailure(accountThrottleKey(email), loginFailureWindow, accountLoginLimits.lockFor)
+	if err != nil {
+		cfg.requestLogger(r).Error("Couldn't record login failure", "err", err)
+	}
+	_, err = cfg.DB.RecordLoginFailure(ipThrottleKey(r), loginFailureWindow, ipLoginLimits.lockFor)
+	if err != nil {
+		cfg.requestLogger(r).Error("Couldn't record login failure", "err", err)
+	}
+}
+
+// recordResetRequest counts a password reset request against the account
+// and the IP, whether or not the account exists
+func (cfg *apiConfig) recordResetRequest(r *http.Request, email string) {
+	_, err := cfg.DB.RecordLoginFailure(resetThrottleKey(accountThrottleKey(email)), loginFailureWindow, accountResetLimits.lockFor)
+	if err != nil {
+		cfg.requestLogger(r).Error("Couldn't record password reset request", "err", err)
+	}
+	_, err = cfg.DB.RecordLoginFailure(resetThrottleKey(ipThrottleKey(r)), loginFailureWindow, ipResetLimits.lockFor)
+	if err != nil {
+		cfg.requestLogger(r).Error("Couldn't record password reset request", "err", err)
+	}
+}
+
+// clearLoginFailures resets the account's count after a successful login. The
+// IP's count is kept, so one good account doesn't let an IP keep guessing.
+func (cfg *apiConfig) clearLoginFailures(email string) {
+	err := cfg.DB.ClearLoginFailures(accountThrottleKey(email))
+	if err != nil {
+		cfg.logger.Error("Couldn't clear login failures", "err", err)
+	}
+}
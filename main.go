@@ -2,65 +2,420 @@
 package main
 
 import (
-	"log"
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/Chaitanya-Shahare/chirpy/internal/blobstore"
+	"github.com/Chaitanya-Shahare/chirpy/internal/config"
 	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/handoff"
+	"github.com/Chaitanya-Shahare/chirpy/internal/keyring"
+	"github.com/Chaitanya-Shahare/chirpy/internal/logging"
+	"github.com/Chaitanya-Shahare/chirpy/internal/mailer"
+	"github.com/Chaitanya-Shahare/chirpy/internal/password"
+	"github.com/Chaitanya-Shahare/chirpy/internal/secretbox"
+	"github.com/Chaitanya-Shahare/chirpy/internal/stream"
+	"github.com/Chaitanya-Shahare/chirpy/internal/webhook"
 	"github.com/joho/godotenv"
 )
 
+// acceptGracePeriod is how long shutting down waits between closing the
+// listener and draining requests
+const acceptGracePeriod = 250 * time.Millisecond
+
 type apiConfig struct {
-	fileserverHits int
-	DB             *database.DB
-	jwtSecret      string
-	polkaAPIKey    string
+	metrics     *serverMetrics
+	DB          *database.DB
+	jwtKeys     *keyring.KeyRing
+	polkaAPIKey string
+	// polkaWebhooks verifies signed Polka webhooks. When nil they are
+	// authenticated with polkaAPIKey instead.
+	polkaWebhooks  *webhook.Verifier
+	adminAPIKey    string
+	totpBox        *secretbox.Box
+	mailer         mailer.Mailer
+	publicURL      string
+	passwords      *password.Hasher
+	passwordPolicy *password.Policy
+	blobs          blobstore.BlobStore
+	// anonymizeDeletedChirps keeps the chirps of deleted accounts, without
+	// an author, instead of deleting them
+	anonymizeDeletedChirps bool
+	// dummyPasswordHash is verified against when a login names an unknown
+	// email, so it takes as long as one with a wrong password
+	dummyPasswordHash string
+	// webhookDispatcher delivers events to registered webhook endpoints
+	webhookDispatcher *webhookDispatcher
+	// webhookAllowInsecure allows endpoints on plain HTTP and private
+	// addresses, for local development
+	webhookAllowInsecure bool
+	// chirpStream publishes chirp events to streaming clients
+	chirpStream *stream.Broker
+	// notificationHub pushes notifications to open sockets
+	notificationHub *notificationHub
+	// config is the configuration the server was started with
+	config *config.Config
+	logger *slog.Logger
+	// background tracks jobs started by requests that shutting down waits
+	// for, like sending email
+	background sync.WaitGroup
 }
 
 func main() {
 	godotenv.Load()
-	jwtSecret := os.Getenv("JWT_SECRET")
-	polkaAPIKey := os.Getenv("POLKA_API_KEY")
+	conf, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+
+	logger, err := logging.New(os.Stderr, conf.LogFormat, conf.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// Route the standard library's logging through the logger too
+	slog.SetDefault(logger)
+	fatal := func(msg string, err error) {
+		logger.Error(msg, "err", err)
+		os.Exit(1)
+	}
+
+	serverMetrics := newServerMetrics()
+
+	db, err := database.NewDB(conf.DBPath)
+	if err != nil {
+		fatal("Couldn't open database", err)
+	}
+	db.ObserveOperations(serverMetrics.observeDB)
+
+	// Without a key directory tokens fall back to HS256 with JWT_SECRET
+	jwtKeys := keyring.NewHMAC(conf.JWTSecret)
+	if conf.JWTKeysDir != "" {
+		jwtKeys, err = keyring.Load(conf.JWTKeysDir)
+		if err != nil {
+			fatal("Couldn't load signing keys", err)
+		}
+	} else {
+		logger.Warn("JWT_KEYS_DIR is unset, so tokens are signed with the shared JWT_SECRET (HS256) and no public keys are published; other services can't verify them")
+	}
+
+	// Two-factor enrollment is unavailable without an encryption key. The
+	// key was checked while loading the configuration.
+	var totpBox *secretbox.Box
+	if conf.TOTPEncryptionKey != "" {
+		key, _ := base64.StdEncoding.DecodeString(conf.TOTPEncryptionKey)
+		totpBox, err = secretbox.New(key)
+		if err != nil {
+			fatal("Invalid TOTP_ENCRYPTION_KEY", err)
+		}
+	}
+
+	// Without an SMTP server emails are written to files for development
+	var mail mailer.Mailer = &mailer.FileMailer{Dir: conf.MailDir, From: conf.MailFrom}
+	if conf.SMTPAddr != "" {
+		mail = &mailer.SMTPMailer{
+			Addr:     conf.SMTPAddr,
+			From:     conf.MailFrom,
+			Username: conf.SMTPUsername,
+			Password: conf.SMTPPassword,
+		}
+	}
+
+	passwordHasher := &password.Hasher{
+		Params:        password.DefaultParams,
+		MaxConcurrent: conf.PasswordMaxConcurrentHashes,
+	}
+	passwordHasher.Params.Memory = uint32(conf.PasswordArgon2MemoryKiB)
+	passwordHasher.Params.Iterations = uint32(conf.PasswordArgon2Iterations)
+	passwordHasher.Params.Parallelism = uint8(conf.PasswordArgon2Parallelism)
+
+	dummyPasswordHash, err := passwordHasher.Hash("chirpy-dummy-password")
+	if err != nil {
+		fatal("Couldn't hash dummy password", err)
+	}
+
+	passwordPolicy := &password.Policy{
+		MinLength: conf.PasswordMinLength,
+		MaxLength: 1024,
+	}
+	if conf.PasswordBreachedList != "" {
+		err = passwordPolicy.LoadBreachedList(conf.PasswordBreachedList)
+		if err != nil {
+			fatal("Couldn't load PASSWORD_BREACHED_LIST", err)
+		}
+	}
+
+	// Several secrets can be active while one is rotated
+	var polkaWebhooks *webhook.Verifier
+	if len(conf.PolkaWebhookSecrets) > 0 {
+		polkaWebhooks = &webhook.Verifier{Tolerance: webhook.DefaultTolerance}
+		for _, secret := range conf.PolkaWebhookSecrets {
+			polkaWebhooks.Secrets = append(polkaWebhooks.Secrets, []byte(secret))
+		}
+	}
 
-	const filepathRoot = "."
-	const port = "8080"
+	blobs, err := blobstore.NewLocalStore(conf.MediaDir)
+	if err != nil {
+		fatal("Couldn't open MEDIA_DIR", err)
+	}
 
-	db, err := database.NewDB("database.json")
+	webhookSender := &webhook.Sender{
+		Client:    webhook.NewClient(10*time.Second, conf.WebhookAllowInsecure),
+		UserAgent: "Chirpy-Webhooks/1.0",
+	}
+	dispatcher := newWebhookDispatcher(db, webhookSender, logger, serverMetrics.webhookDeliveries, conf.WebhookRetention)
+
+	// Only one process may write the database. During a handoff the previous
+	// process releases it once it has drained, so this one tells it to start
+	// draining and waits; otherwise another process holding it is a mistake.
+	ln, err := handoff.Listen(":" + strconv.Itoa(conf.Port))
 	if err != nil {
-		log.Fatal(err)
+		fatal("Couldn't listen", err)
+	}
+	lockTimeout := time.Duration(0)
+	if handoff.Inherited() {
+		if err := handoff.Started(); err != nil {
+			fatal("Couldn't signal the previous process", err)
+		}
+		lockTimeout = 2 * conf.ShutdownTimeout
+	}
+	if err := db.Lock(lockTimeout); err != nil {
+		fatal("Couldn't lock database", err)
 	}
 
+	dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		dispatcher.run(dispatcherCtx)
+	}()
+
 	apiCfg := apiConfig{
-		fileserverHits: 0,
+		metrics:        serverMetrics,
 		DB:             db,
-		jwtSecret:      jwtSecret,
-		polkaAPIKey:    polkaAPIKey,
+		jwtKeys:        jwtKeys,
+		polkaAPIKey:    conf.PolkaAPIKey,
+		polkaWebhooks:  polkaWebhooks,
+		adminAPIKey:    conf.AdminAPIKey,
+		totpBox:        totpBox,
+		mailer:         mail,
+		publicURL:      conf.PublicURL,
+		passwords:      passwordHasher,
+		passwordPolicy: passwordPolicy,
+		blobs:          blobs,
+
+		anonymizeDeletedChirps: conf.DeletedUserChirps == "anonymize",
+		dummyPasswordHash:      dummyPasswordHash,
+		webhookDispatcher:      dispatcher,
+		webhookAllowInsecure:   conf.WebhookAllowInsecure,
+		// IDs start from the clock so a client resuming from a previous
+		// run is told to reset rather than resumed from the wrong event
+		chirpStream:     stream.NewBroker(uint64(time.Now().UnixNano()), 1000, 64),
+		notificationHub: newNotificationHub(logger),
+		config:          &conf,
+		logger:          logger,
 	}
 
+	mediaCleanupCtx, stopMediaCleanup := context.WithCancel(context.Background())
+	mediaCleanupDone := make(chan struct{})
+	go func() {
+		defer close(mediaCleanupDone)
+		apiCfg.cleanUpMedia(mediaCleanupCtx, conf.MediaOrphanTTL)
+	}()
+
 	mux := http.NewServeMux()
-	fsHandler := apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot))))
+	fsHandler := apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(conf.FileRoot))))
 	mux.Handle("/app/*", fsHandler)
 
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	mux.HandleFunc("GET /metrics", apiCfg.handlerPrometheus)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
 	mux.HandleFunc("GET /api/reset", apiCfg.handlerReset)
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsRetrieve)
+	mux.HandleFunc("GET /api/chirps/stream", apiCfg.handlerChirpsStream)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpsGet)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
+	mux.HandleFunc("POST /api/media", apiCfg.handlerMediaUpload)
+	mux.HandleFunc("DELETE /api/media/{mediaID}", apiCfg.handlerMediaDelete)
+	mux.HandleFunc("GET /media/{key}", apiCfg.handlerMediaServe)
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
 	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdate)
+	mux.HandleFunc("PATCH /api/users", apiCfg.handlerUsersPatch)
+	mux.HandleFunc("DELETE /api/users", apiCfg.handlerUsersDelete)
+	mux.HandleFunc("GET /api/users/me/export", apiCfg.handlerUsersExport)
+	mux.HandleFunc("POST /api/users/email/confirm", apiCfg.handlerUsersEmailConfirm)
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerUsersVerify)
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.handlerUsersVerifyResend)
+	mux.HandleFunc("PUT /api/users/avatar", apiCfg.handlerAvatarUpload)
+	mux.HandleFunc("DELETE /api/users/avatar", apiCfg.handlerAvatarDelete)
+	mux.HandleFunc("GET /api/users/{idOrHandle}", apiCfg.handlerUsersGet)
+	mux.HandleFunc("POST /api/users/{idOrHandle}/follow", apiCfg.handlerFollowsCreate)
+	mux.HandleFunc("DELETE /api/users/{idOrHandle}/follow", apiCfg.handlerFollowsDelete)
+	mux.HandleFunc("GET /api/notifications", apiCfg.handlerNotificationsList)
+	mux.HandleFunc("GET /api/notifications/ws", apiCfg.handlerNotificationsSocket)
+	mux.HandleFunc("POST /api/notifications/read-all", apiCfg.handlerNotificationsReadAll)
+	mux.HandleFunc("GET /api/notifications/preferences", apiCfg.handlerNotificationPreferencesGet)
+	mux.HandleFunc("PUT /api/notifications/preferences", apiCfg.handlerNotificationPreferencesUpdate)
+	mux.HandleFunc("POST /api/notifications/{notificationID}/read", apiCfg.handlerNotificationsRead)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerPasswordReset)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/2fa", apiCfg.handlerLoginTwoFactor)
+	mux.HandleFunc("POST /api/2fa/enroll", apiCfg.handlerTwoFactorEnroll)
+	mux.HandleFunc("POST /api/2fa/confirm", apiCfg.handlerTwoFactorConfirm)
+	mux.HandleFunc("DELETE /api/2fa", apiCfg.handlerTwoFactorDisable)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
+	mux.HandleFunc("GET /api/sessions", apiCfg.handlerSessionsList)
+	mux.HandleFunc("DELETE /api/sessions", apiCfg.handlerSessionsDeleteOthers)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.handlerSessionsDelete)
+	mux.HandleFunc("POST /api/tokens", apiCfg.handlerTokensCreate)
+	mux.HandleFunc("GET /api/tokens", apiCfg.handlerTokensList)
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", apiCfg.handlerTokensDelete)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhooks)
+	mux.HandleFunc("POST /api/webhooks", apiCfg.handlerWebhookEndpointsCreate)
+	mux.HandleFunc("GET /api/webhooks", apiCfg.handlerWebhookEndpointsList)
+	mux.HandleFunc("DELETE /api/webhooks/{endpointID}", apiCfg.handlerWebhookEndpointsDelete)
+	mux.HandleFunc("POST /api/webhooks/{endpointID}/enable", apiCfg.handlerWebhookEndpointsEnable)
+	mux.HandleFunc("GET /api/webhooks/{endpointID}/deliveries", apiCfg.handlerWebhookEndpointsDeliveries)
 
 	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	mux.HandleFunc("GET /admin/config", apiCfg.handlerAdminConfig)
+	mux.HandleFunc("POST /admin/users/{userID}/suspend", apiCfg.handlerAdminUsersSuspend)
+	mux.HandleFunc("GET /admin/webhooks", apiCfg.handlerAdminWebhooksList)
+	mux.HandleFunc("GET /admin/webhooks/dead-letter", apiCfg.handlerAdminWebhooksDeadLetter)
+	mux.HandleFunc("GET /admin/webhooks/{deliveryID}", apiCfg.handlerAdminWebhooksGet)
+	mux.HandleFunc("POST /admin/webhooks/{deliveryID}/replay", apiCfg.handlerAdminWebhooksReplay)
+	mux.HandleFunc("POST /admin/webhook-endpoints", apiCfg.handlerAdminWebhookEndpointsCreate)
+	mux.HandleFunc("GET /admin/webhook-endpoints", apiCfg.handlerAdminWebhookEndpointsList)
+	mux.HandleFunc("DELETE /admin/webhook-endpoints/{endpointID}", apiCfg.handlerAdminWebhookEndpointsDelete)
+	mux.HandleFunc("POST /admin/webhook-endpoints/{endpointID}/enable", apiCfg.handlerAdminWebhookEndpointsEnable)
+	mux.HandleFunc("GET /admin/webhook-endpoints/{endpointID}/deliveries", apiCfg.handlerAdminWebhookEndpointsDeliveries)
 
+	// Streams and sockets manage their own deadlines, so the timeouts
+	// only bound ordinary requests
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:              ":" + strconv.Itoa(conf.Port),
+		Handler:           apiCfg.middlewareObserve(apiCfg.middlewareBodyLimit(mux), mux),
+		ReadHeaderTimeout: conf.ReadHeaderTimeout,
+		ReadTimeout:       conf.ReadTimeout,
+		WriteTimeout:      conf.WriteTimeout,
+		IdleTimeout:       conf.IdleTimeout,
+		MaxHeaderBytes:    conf.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 	}
 
-	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
-	log.Fatal(srv.ListenAndServe())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+	if err := handoff.Ready(); err != nil {
+		logger.Error("Couldn't signal the previous process", "err", err)
+	}
+
+	logger.Info("Serving", "file_root", conf.FileRoot, "port", conf.Port)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	if handoff.Signal != nil {
+		signal.Notify(signals, handoff.Signal)
+	}
+
+	// successor is the process the listener was handed to, if any
+	var successor *handoff.Process
+waitForSignal:
+	for {
+		select {
+		case err := <-serveErr:
+			fatal("Server failed", err)
+		case sig := <-signals:
+			if sig != handoff.Signal {
+				logger.Info("Shutting down", "signal", sig.String())
+				break waitForSignal
+			}
+			process, err := handoff.Start(ln, conf.ShutdownTimeout)
+			if err != nil {
+				logger.Error("Couldn't hand over the listener, still serving", "err", err)
+				continue
+			}
+			logger.Info("Handed over the listener, shutting down", "pid", process.Pid)
+			successor = process
+			break waitForSignal
+		}
+	}
+	signal.Stop(signals)
+
+	// Shutdown drops requests that arrive after it starts, even on
+	// connections accepted just before, so stop accepting first and give
+	// those connections a moment to send their requests
+	ln.Close()
+	time.Sleep(acceptGracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+
+	// Streams never go idle on their own, so end them first; clients
+	// reconnect to whichever process is listening next. Deliveries queued
+	// by the requests being drained are left to that process too.
+	apiCfg.chirpStream.Close()
+	socketsClosed := apiCfg.notificationHub.close()
+	stopDispatcher()
+	stopMediaCleanup()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("Couldn't drain requests", "err", err)
+		srv.Close()
+	}
+
+	jobsDone := make(chan struct{})
+	go func() {
+		apiCfg.background.Wait()
+		close(jobsDone)
+	}()
+	for _, wait := range []struct {
+		name string
+		done <-chan struct{}
+	}{
+		{"notification sockets", socketsClosed},
+		{"webhook dispatcher", dispatcherDone},
+		{"media cleanup", mediaCleanupDone},
+		{"background jobs", jobsDone},
+	} {
+		select {
+		case <-wait.done:
+		case <-ctx.Done():
+			logger.Warn("Gave up waiting for " + wait.name)
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		logger.Error("Couldn't close database", "err", err)
+	}
+
+	// The new process only starts serving once it has the database, so
+	// nothing is serving between the two if it fails now
+	if successor != nil {
+		if err := successor.Wait(conf.ShutdownTimeout); err != nil {
+			logger.Error("New process didn't take over", "pid", successor.Pid, "err", err)
+		} else {
+			logger.Info("New process took over", "pid", successor.Pid)
+		}
+	}
+	logger.Info("Server stopped")
 }
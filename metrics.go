@@ -3,9 +3,101 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/metrics"
 )
 
+// serverMetrics are the metrics served at /metrics and summarized on the
+// admin page.
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	fileserverHits *metrics.Counter
+	// hitsResetAt is the fileserver hit count when /api/reset was last
+	// called, so resetting doesn't move the counter backwards
+	hitsResetAt atomic.Uint64
+
+	requests          *metrics.CounterVec
+	requestDuration   *metrics.HistogramVec
+	dbDuration        *metrics.HistogramVec
+	chirpsCreated     *metrics.Counter
+	logins            *metrics.CounterVec
+	webhookDeliveries *metrics.CounterVec
+	polkaWebhooks     *metrics.CounterVec
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		fileserverHits: r.NewCounter("chirpy_fileserver_hits_total",
+			"Requests for files under /app.").With(),
+		requests: r.NewCounter("chirpy_http_requests_total",
+			"HTTP requests by method, route and status.", "method", "route", "status"),
+		requestDuration: r.NewHistogram("chirpy_http_request_duration_seconds",
+			"Time taken to handle HTTP requests by method and route.", metrics.DefaultBuckets, "method", "route"),
+		dbDuration: r.NewHistogram("chirpy_db_operation_duration_seconds",
+			"Time taken to load or write the database file.", metrics.DefaultBuckets, "operation"),
+		chirpsCreated: r.NewCounter("chirpy_chirps_created_total",
+			"Chirps created.").With(),
+		logins: r.NewCounter("chirpy_logins_total",
+			"Login attempts by result, success or failure.", "result"),
+		webhookDeliveries: r.NewCounter("chirpy_webhook_deliveries_total",
+			"Outbound webhook delivery attempts by result: delivered, retrying or failed.", "result"),
+		polkaWebhooks: r.NewCounter("chirpy_polka_webhooks_total",
+			"Polka webhook events by the status processing them left them in.", "status"),
+	}
+
+	// Start the expected series at zero so they show up before they happen
+	for _, result := range []string{"success", "failure"} {
+		m.logins.With(result)
+	}
+	for _, result := range []string{"delivered", "retrying", "failed"} {
+		m.webhookDeliveries.With(result)
+	}
+	return m
+}
+
+// observeRequest records a request's metrics. Methods outside the standard
+// set are counted together so clients can't create unbounded series.
+func (m *serverMetrics) observeRequest(method, route string, status int, elapsed time.Duration) {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		method = "OTHER"
+	}
+	if route == "" {
+		route = "unmatched"
+	}
+	m.requests.With(method, route, fmt.Sprint(status)).Inc()
+	m.requestDuration.With(method, route).Observe(elapsed.Seconds())
+}
+
+func (m *serverMetrics) observeDB(operation string, elapsed time.Duration) {
+	m.dbDuration.With(operation).Observe(elapsed.Seconds())
+}
+
+// handlerPrometheus serves every metric in the Prometheus text format. Like
+// the admin endpoints it needs the admin API key unless METRICS_PUBLIC is
+// set, since the metrics reveal traffic and login failures.
+func (cfg *apiConfig) handlerPrometheus(w http.ResponseWriter, r *http.Request) {
+	if !cfg.config.MetricsPublic && !cfg.authorizeAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API Key")
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.WriteHeader(http.StatusOK)
+	cfg.metrics.registry.WriteText(w)
+}
+
 func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	m := cfg.metrics
+	hits := m.fileserverHits.Value() - m.hitsResetAt.Load()
+
 	w.Header().Add("Content-Type", "text/html")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf(`
@@ -13,16 +105,25 @@ func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 
 <body>
 	<h1>Welcome, Chirpy Admin</h1>
-	<p>Chirpy has been visited %dtimes!</p>
+	<p>Chirpy has been visited %d times!</p>
+	<ul>
+		<li>Chirps created: %d</li>
+		<li>Logins succeeded: %d, failed: %d</li>
+		<li>Webhook deliveries succeeded: %d, failed: %d</li>
+	</ul>
+	<p>All metrics are at <a href="/metrics">/metrics</a>.</p>
 </body>
 
 </html>
-	`, cfg.fileserverHits)))
+	`, hits, m.chirpsCreated.Value(),
+		m.logins.With("success").Value(), m.logins.With("failure").Value(),
+		m.webhookDeliveries.With("delivered").Value(),
+		m.webhookDeliveries.With("retrying").Value()+m.webhookDeliveries.With("failed").Value())))
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits++
+		cfg.metrics.fileserverHits.Inc()
 		next.ServeHTTP(w, r)
 	})
 }
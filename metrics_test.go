@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusNeedsAdminKey(t *testing.T) {
+	cfg, _ := newTestAPIConfig(t)
+	cfg.adminAPIKey = "admin-key"
+
+	scrape := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		cfg.handlerPrometheus(rec, req)
+		return rec
+	}
+
+	for _, auth := range []string{"", "ApiKey wrong-key", "Bearer admin-key", "admin-key"} {
+		if rec := scrape(auth); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status %d, want %d", auth, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	rec := scrape("ApiKey admin-key")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "chirpy_http_requests_total") {
+		t.Errorf("with the admin key: status %d: %s", rec.Code, rec.Body)
+	}
+
+	cfg.config.MetricsPublic = true
+	if rec := scrape(""); rec.Code != http.StatusOK {
+		t.Errorf("public metrics without a key: status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
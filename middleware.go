@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// requestIDHeader carries the ID that ties a request to its log lines
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits the IDs accepted from clients to ones that are safe
+// to log and echo
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+type requestLogKey struct{}
+
+// requestLog collects what handlers know about a request for its access
+// log line.
+type requestLog struct {
+	id     string
+	userID int
+	// err is the message of a 5XX response
+	err string
+}
+
+// middlewareBodyLimit caps every request body at the configured maximum.
+// Routes that read bodies set lower limits of their own.
+func (cfg *apiConfig) middlewareBodyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > int64(cfg.config.MaxBodyBytes) {
+			respondWithBodyTooLarge(w, int64(cfg.config.MaxBodyBytes))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, int64(cfg.config.MaxBodyBytes))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareObserve logs a line and records metrics for every request once
+// it is done, with the route it matched in routes. Each request gets an ID,
+// the client's X-Request-ID if it sent a valid one, which is echoed in the
+// response.
+func (cfg *apiConfig) middlewareObserve(next http.Handler, routes *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		reqLog := &requestLog{id: id}
+		lw := &accessLogWriter{ResponseWriter: w, log: reqLog}
+		r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, reqLog))
+
+		// Look the route up first; handlers may change the request's URL
+		_, route := routes.Handler(r)
+		method, path, query := r.Method, r.URL.Path, r.URL.RawQuery
+
+		next.ServeHTTP(lw, r)
+
+		elapsed := time.Since(start)
+		status := lw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		cfg.metrics.observeRequest(method, route, status, elapsed)
+
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", method),
+			slog.String("route", route),
+			slog.String("path", path),
+			// Tokens in the query are redacted by the logger
+			slog.String("query", query),
+			slog.Int("status", status),
+			slog.Duration("duration", elapsed),
+			slog.Int64("bytes", lw.bytes),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if reqLog.userID != 0 {
+			attrs = append(attrs, slog.Int("user_id", reqLog.userID))
+		}
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+			attrs = append(attrs, slog.String("error", reqLog.err))
+		}
+		cfg.logger.LogAttrs(context.Background(), level, "request", attrs...)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestLogger returns the logger for messages about a request, which
+// carry its ID.
+func (cfg *apiConfig) requestLogger(r *http.Request) *slog.Logger {
+	if reqLog, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		return cfg.logger.With("request_id", reqLog.id)
+	}
+	return cfg.logger
+}
+
+// setRequestUser records who made the request in its access log line.
+func setRequestUser(r *http.Request, userID int) {
+	if reqLog, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		reqLog.userID = userID
+	}
+}
+
+// setResponseError records why the request failed in its access log line.
+func setResponseError(w http.ResponseWriter, msg string) {
+	if lw, ok := w.(*accessLogWriter); ok {
+		lw.log.err = msg
+	}
+}
+
+// accessLogWriter records the status and size of a response.
+type accessLogWriter struct {
+	http.ResponseWriter
+	log    *requestLog
+	status int
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Hijack records the switch to a WebSocket, whose traffic isn't counted.
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the server's writer.
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
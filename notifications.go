@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+// maxMentionsPerChirp caps the notifications one chirp can send
+const maxMentionsPerChirp = 10
+
+// notificationTypes are the types users set preferences for. Moderation
+// notices can't be turned off.
+var notificationTypes = []string{
+	database.NotificationMention,
+	database.NotificationFollow,
+	database.NotificationChirpyRed,
+	database.NotificationModeration,
+}
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_@])@([A-Za-z][A-Za-z0-9_]{2,14})\b`)
+
+type Notification struct {
+	ID      int          `json:"id"`
+	Type    string       `json:"type"`
+	Actor   *ChirpAuthor `json:"actor,omitempty"`
+	ChirpID int          `json:"chirp_id,omitempty"`
+	Detail  string       `json:"detail,omitempty"`
+	Read    bool         `json:"read"`
+	// ReadAt is when the notification was marked read
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// notify adds a notification to the user's inbox and pushes it to their
+// open connections. Users aren't notified of their own actions or of types
+// they muted, and failing to notify doesn't fail the request that caused it.
+func (cfg *apiConfig) notify(notification database.Notification) {
+	if notification.UserID == 0 || notification.UserID == notification.ActorID {
+		return
+	}
+
+	created, err := cfg.DB.CreateNotification(notification)
+	if errors.Is(err, database.ErrNotificationMuted) {
+		return
+	}
+	if err != nil {
+		cfg.logger.Error("Couldn't create notification", "type", notification.Type, "err", err)
+		return
+	}
+
+	notifications, err := cfg.newNotifications([]database.Notification{created})
+	if err != nil {
+		cfg.logger.Error("Couldn't load notification", "type", notification.Type, "err", err)
+		return
+	}
+	cfg.notificationHub.publish(created.UserID, created.ID, socketMessage{
+		Type:         "notification",
+		Notification: &notifications[0],
+	})
+}
+
+// notifyMentions notifies the users mentioned with @handle in a chirp.
+func (cfg *apiConfig) notifyMentions(chirp database.Chirp) {
+	handles := []string{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(chirp.Body, -1) {
+		handles = append(handles, match[1])
+	}
+
+	notified := map[int]bool{}
+	for _, handle := range handles {
+		if len(notified) == maxMentionsPerChirp {
+			break
+		}
+		user, err := cfg.DB.GetUserByHandle(handle)
+		if err != nil || notified[user.ID] {
+			continue
+		}
+		notified[user.ID] = true
+		cfg.notify(database.Notification{
+			UserID:  user.ID,
+			Type:    database.NotificationMention,
+			ActorID: chirp.AuthorID,
+			ChirpID: chirp.ID,
+		})
+	}
+}
+
+// markNotificationsRead marks the given notifications of the user read and
+// tells their open connections which ones.
+func (cfg *apiConfig) markNotificationsRead(userID int, ids []int) ([]int, error) {
+	marked, err := cfg.DB.MarkNotificationsRead(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	return marked, cfg.publishRead(userID, marked)
+}
+
+// markAllNotificationsRead marks every notification of the user read and
+// tells their open connections which ones.
+func (cfg *apiConfig) markAllNotificationsRead(userID int) ([]int, error) {
+	marked, err := cfg.DB.MarkAllNotificationsRead(userID)
+	if err != nil {
+		return nil, err
+	}
+	return marked, cfg.publishRead(userID, marked)
+}
+
+// publishRead tells the user's open connections which notifications were
+// marked read.
+func (cfg *apiConfig) publishRead(userID int, marked []int) error {
+	if len(marked) == 0 {
+		return nil
+	}
+
+	unread, err := cfg.DB.CountUnreadNotifications(userID)
+	if err != nil {
+		return err
+	}
+	cfg.notificationHub.publish(userID, 0, socketMessage{
+		Type:        "read",
+		IDs:         marked,
+		UnreadCount: &unread,
+	})
+
+	return nil
+}
+
+// newNotifications builds the responses for notifications, looking up every
+// actor once.
+func (cfg *apiConfig) newNotifications(dbNotifications []database.Notification) ([]Notification, error) {
+	actorIDs := make([]int, 0, len(dbNotifications))
+	for _, n := range dbNotifications {
+		actorIDs = append(actorIDs, n.ActorID)
+	}
+	actors, err := cfg.DB.GetUsersByIDs(actorIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := []Notification{}
+	for _, n := range dbNotifications {
+		notification := Notification{
+			ID:        n.ID,
+			Type:      n.Type,
+			ChirpID:   n.ChirpID,
+			Detail:    n.Detail,
+			Read:      n.ReadAt != nil,
+			ReadAt:    n.ReadAt,
+			CreatedAt: n.CreatedAt,
+		}
+		if actor, ok := actors[n.ActorID]; ok {
+			notification.Actor = newChirpAuthor(actor)
+		}
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, nil
+}
+
+// socketMessage is a message sent to notification sockets
+type socketMessage struct {
+	Type         string        `json:"type"`
+	Notification *Notification `json:"notification,omitempty"`
+	IDs          []int         `json:"ids,omitempty"`
+	UnreadCount  *int          `json:"unread_count,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// hubMessage is an encoded socketMessage. NotificationID is set for new
+// notifications so sockets can skip those they already sent.
+type hubMessage struct {
+	NotificationID int
+	Data           []byte
+}
+
+// notificationHub fans messages out to each user's open sockets. Publishing
+// never blocks; a socket that falls behind is closed, and catches up when
+// the client reconnects.
+type notificationHub struct {
+	mu      sync.Mutex
+	sockets map[int]map[*hubSocket]struct{}
+	closed  bool
+	logger  *slog.Logger
+	// active counts sockets between subscribe and unsubscribe
+	active sync.WaitGroup
+}
+
+type hubSocket struct {
+	userID int
+	c      chan hubMessage
+	// signedOut is set before c is closed when the user's tokens were
+	// revoked
+	signedOut bool
+}
+
+func newNotificationHub(logger *slog.Logger) *notificationHub {
+	return &notificationHub{sockets: map[int]map[*hubSocket]struct{}{}, logger: logger}
+}
+
+// subscribe registers a socket for the user's messages. Once the hub is
+// closed the socket's channel is closed straight away.
+func (h *notificationHub) subscribe(userID int) *hubSocket {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.active.Add(1)
+	socket := &hubSocket{userID: userID, c: make(chan hubMessage, 32)}
+	if h.closed {
+		close(socket.c)
+		return socket
+	}
+	if h.sockets[userID] == nil {
+		h.sockets[userID] = map[*hubSocket]struct{}{}
+	}
+	h.sockets[userID][socket] = struct{}{}
+	return socket
+}
+
+// unsubscribe closes the socket's channel unless it was closed already.
+func (h *notificationHub) unsubscribe(socket *hubSocket) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(socket)
+	h.active.Done()
+}
+
+// close closes every socket's channel so their connections shut down, and
+// returns a channel that is closed once they have all unsubscribed.
+func (h *notificationHub) close() <-chan struct{} {
+	h.mu.Lock()
+	h.closed = true
+	for _, sockets := range h.sockets {
+		for socket := range sockets {
+			h.remove(socket)
+		}
+	}
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.active.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// disconnect closes the user's sockets once their tokens are revoked, so
+// signing out everywhere ends them too. Messages already queued are still
+// sent.
+func (h *notificationHub) disconnect(userID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for socket := range h.sockets[userID] {
+		socket.signedOut = true
+		h.remove(socket)
+	}
+}
+
+// isClosed reports whether sockets are closed because the server is
+// shutting down rather than because they fell behind.
+func (h *notificationHub) isClosed() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closed
+}
+
+func (h *notificationHub) publish(userID, notificationID int, msg socketMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		h.logger.Error("Couldn't marshal socket message", "type", msg.Type, "err", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for socket := range h.sockets[userID] {
+		select {
+		case socket.c <- hubMessage{NotificationID: notificationID, Data: data}:
+		default:
+			h.remove(socket)
+		}
+	}
+}
+
+// remove must be called with h.mu held.
+func (h *notificationHub) remove(socket *hubSocket) {
+	sockets := h.sockets[socket.userID]
+	if _, ok := sockets[socket]; !ok {
+		return
+	}
+	delete(sockets, socket)
+	if len(sockets) == 0 {
+		delete(h.sockets, socket.userID)
+	}
+	close(socket.c)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+)
+
+func TestMarkNotificationsRead(t *testing.T) {
+	cfg, _ := newTestAPIConfig(t)
+	user, err := cfg.DB.CreateUser("walt@example.com", "hash", "walt")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		cfg.notify(database.Notification{UserID: user.ID, Type: database.NotificationModeration, Detail: "suspended"})
+	}
+	socket := cfg.notificationHub.subscribe(user.ID)
+	defer cfg.notificationHub.unsubscribe(socket)
+
+	// An empty list marks nothing rather than everything
+	marked, err := cfg.markNotificationsRead(user.ID, []int{})
+	if err != nil || len(marked) != 0 {
+		t.Fatalf("markNotificationsRead(none) = %v, %v; want nothing marked", marked, err)
+	}
+	marked, err = cfg.markNotificationsRead(user.ID, []int{1})
+	if err != nil || len(marked) != 1 {
+		t.Fatalf("markNotificationsRead(1) = %v, %v; want [1]", marked, err)
+	}
+	marked, err = cfg.markAllNotificationsRead(user.ID)
+	if err != nil || len(marked) != 2 {
+		t.Fatalf("markAllNotificationsRead = %v, %v; want the other two", marked, err)
+	}
+
+	// Sockets are told about each change, and nothing else
+	for _, wantUnread := range []int{2, 0} {
+		var msg socketMessage
+		if err := json.Unmarshal((<-socket.c).Data, &msg); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if msg.Type != "read" || msg.UnreadCount == nil || *msg.UnreadCount != wantUnread {
+			t.Errorf("socket message = %+v, want read with %d unread", msg, wantUnread)
+		}
+	}
+	select {
+	case msg := <-socket.c:
+		t.Errorf("unexpected socket message %s", msg.Data)
+	default:
+	}
+}
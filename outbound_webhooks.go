@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/metrics"
+	"github.com/Chaitanya-Shahare/chirpy/internal/webhook"
+)
+
+// Events integrators can subscribe to
+const (
+	eventChirpCreated   = "chirp.created"
+	eventChirpDeleted   = "chirp.deleted"
+	eventUserUpgraded   = "user.upgraded"
+	eventUserDowngraded = "user.downgraded"
+	eventUserFollowed   = "user.followed"
+)
+
+var validEventTypes = []string{eventChirpCreated, eventChirpDeleted, eventUserUpgraded, eventUserDowngraded, eventUserFollowed}
+
+// WebhookEvent is the body of every outbound delivery
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// emitEvent queues an event about userID for the endpoints subscribed to it.
+// Failing to queue an event doesn't fail the request that caused it.
+func (cfg *apiConfig) emitEvent(eventType string, userID int, data interface{}) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		cfg.logger.Error("Couldn't generate event ID", "err", err)
+		return
+	}
+	event := WebhookEvent{
+		ID:        "evt_" + hex.EncodeToString(idBytes),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		cfg.logger.Error("Couldn't marshal event", "type", eventType, "err", err)
+		return
+	}
+
+	queued, err := cfg.DB.EnqueueWebhookEvent(event.ID, eventType, userID, payload)
+	if err != nil {
+		cfg.logger.Error("Couldn't queue event", "type", eventType, "err", err)
+		return
+	}
+	if queued > 0 {
+		cfg.webhookDispatcher.notify()
+	}
+}
+
+// webhookDispatcher delivers queued events in the background. Failed
+// attempts are retried with exponential backoff until maxAttempts, and an
+// endpoint that fails disableAfter times in a row is disabled. Each endpoint
+// gets at most perEndpoint attempts at once, so a slow endpoint only holds up
+// its own deliveries. The dispatcher also prunes webhook history, inbound and
+// outbound, once it is older than retention.
+type webhookDispatcher struct {
+	db     *database.DB
+	sender *webhook.Sender
+	logger *slog.Logger
+	// outcomes counts attempts by result: delivered, retrying or failed
+	outcomes *metrics.CounterVec
+	wake     chan struct{}
+
+	maxAttempts  int
+	disableAfter int
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	// pollInterval bounds how long the dispatcher sleeps, in case another
+	// process queued deliveries
+	pollInterval time.Duration
+	batchSize    int
+	perEndpoint  int
+	concurrency  int
+
+	retention     time.Duration
+	pruneInterval time.Duration
+	lastPruned    time.Time
+
+	mu sync.Mutex
+	// inFlight holds the deliveries being attempted and busy counts them
+	// by endpoint
+	inFlight map[int]bool
+	busy     map[int]int
+	attempts sync.WaitGroup
+}
+
+func newWebhookDispatcher(db *database.DB, sender *webhook.Sender, logger *slog.Logger, outcomes *metrics.CounterVec, retention time.Duration) *webhookDispatcher {
+	return &webhookDispatcher{
+		db:           db,
+		sender:       sender,
+		logger:       logger,
+		outcomes:     outcomes,
+		wake:         make(chan struct{}, 1),
+		maxAttempts:  10,
+		disableAfter: 20,
+		baseBackoff:  30 * time.Second,
+		maxBackoff:   6 * time.Hour,
+		pollInterval: time.Minute,
+		batchSize:    50,
+		perEndpoint:  2,
+		concurrency:  16,
+
+		retention:     retention,
+		pruneInterval: time.Hour,
+
+		inFlight: map[int]bool{},
+		busy:     map[int]int{},
+	}
+}
+
+// notify wakes the dispatcher up to deliver newly queued events.
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers events until ctx is cancelled, then waits for the attempts
+// under way.
+func (d *webhookDispatcher) run(ctx context.Context) {
+	defer d.attempts.Wait()
+
+	for {
+		if time.Since(d.lastPruned) >= d.pruneInterval {
+			d.prune()
+		}
+		next := d.deliverDue(ctx)
+
+		wait := d.pollInterval
+		if !next.IsZero() {
+			wait = min(wait, time.Until(next))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// deliverDue starts attempts at the due deliveries there is room for and
+// returns when the next one is due, or the zero time if none is queued.
+// Finished attempts wake the dispatcher up to start more.
+func (d *webhookDispatcher) deliverDue(ctx context.Context) time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	started := len(d.inFlight)
+	picked := map[int]int{}
+	deliveries, endpoints, next, err := d.db.GetDueDeliveries(time.Now().UTC(), d.batchSize, func(delivery database.OutboundDelivery) bool {
+		if d.inFlight[delivery.ID] || started >= d.concurrency ||
+			d.busy[delivery.EndpointID]+picked[delivery.EndpointID] >= d.perEndpoint {
+			return true
+		}
+		started++
+		picked[delivery.EndpointID]++
+		return false
+	})
+	if err != nil {
+		d.logger.Error("Couldn't load webhook deliveries", "err", err)
+		return time.Time{}
+	}
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			break
+		}
+		endpoint, ok := endpoints[delivery.EndpointID]
+		if !ok || endpoint.ID == 0 {
+			continue
+		}
+
+		d.inFlight[delivery.ID] = true
+		d.busy[endpoint.ID]++
+		d.attempts.Add(1)
+		go func() {
+			defer d.attempts.Done()
+			d.attempt(ctx, delivery, endpoint)
+
+			d.mu.Lock()
+			delete(d.inFlight, delivery.ID)
+			if d.busy[endpoint.ID]--; d.busy[endpoint.ID] == 0 {
+				delete(d.busy, endpoint.ID)
+			}
+			d.mu.Unlock()
+			d.notify()
+		}()
+	}
+
+	return next
+}
+
+// prune deletes finished deliveries older than the retention period.
+func (d *webhookDispatcher) prune() {
+	d.lastPruned = time.Now()
+	cutoff := d.lastPruned.UTC().Add(-d.retention)
+
+	outbound, err := d.db.PruneOutboundDeliveries(cutoff)
+	if err != nil {
+		d.logger.Error("Couldn't prune outbound webhook deliveries", "err", err)
+	}
+	inbound, err := d.db.PruneWebhookEvents(cutoff)
+	if err != nil {
+		d.logger.Error("Couldn't prune webhook events", "err", err)
+	}
+	if outbound > 0 || inbound > 0 {
+		d.logger.Info("Pruned webhook history", "outbound", outbound, "inbound", inbound)
+	}
+}
+
+func (d *webhookDispatcher) attempt(ctx context.Context, delivery database.OutboundDelivery, endpoint database.WebhookEndpoint) {
+	status, err := d.sender.Send(ctx, endpoint.URL, []byte(endpoint.Secret), delivery.EventID, delivery.EventType, delivery.Payload)
+	// Attempts cut short by shutting down don't count
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	result := database.AttemptResult{
+		Success:    err == nil,
+		StatusCode: status,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		// Endpoints on forbidden addresses won't become reachable by retrying
+		if delivery.Attempts+1 < d.maxAttempts && !errors.Is(err, webhook.ErrForbiddenAddress) {
+			result.NextAttemptAt = time.Now().UTC().Add(d.backoff(delivery.Attempts + 1))
+		}
+	}
+
+	disabled, err := d.db.RecordDeliveryAttempt(delivery.ID, result, d.disableAfter)
+	if err != nil {
+		d.logger.Error("Couldn't record webhook delivery", "delivery_id", delivery.ID, "err", err)
+		return
+	}
+
+	switch {
+	case result.Success:
+		d.outcomes.With("delivered").Inc()
+	case !result.NextAttemptAt.IsZero():
+		d.outcomes.With("retrying").Inc()
+	default:
+		d.outcomes.With("failed").Inc()
+	}
+	if disabled {
+		d.logger.Warn("Disabled webhook endpoint after failed deliveries", "endpoint_id", endpoint.ID, "failures", d.disableAfter)
+	}
+}
+
+// backoff returns the delay before the attempt after the nth failure. It
+// doubles each time, up to maxBackoff, with up to 20% jitter so endpoints
+// coming back up aren't hit by every retry at once.
+func (d *webhookDispatcher) backoff(failures int) time.Duration {
+	delay := d.maxBackoff
+	if failures < 32 {
+		delay = min(d.baseBackoff<<(failures-1), d.maxBackoff)
+	}
+	return delay + time.Duration(mathrand.Int64N(int64(delay)/5+1))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Chaitanya-Shahare/chirpy/internal/database"
+	"github.com/Chaitanya-Shahare/chirpy/internal/webhook"
+)
+
+// testReceiver records the deliveries an endpoint receives. While hold is
+// open, it doesn't answer.
+type testReceiver struct {
+	secret []byte
+	hold   chan struct{}
+
+	mu          sync.Mutex
+	received    []string
+	inFlight    int
+	maxInFlight int
+}
+
+func (rcv *testReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	verifier := webhook.Verifier{Secrets: [][]byte{rcv.secret}}
+	if _, err := verifier.Verify(r.Header.Get(webhook.SignatureHeader), body, time.Now()); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	rcv.mu.Lock()
+	rcv.inFlight++
+	rcv.maxInFlight = max(rcv.maxInFlight, rcv.inFlight)
+	rcv.mu.Unlock()
+
+	if rcv.hold != nil {
+		<-rcv.hold
+	}
+
+	rcv.mu.Lock()
+	rcv.inFlight--
+	rcv.received = append(rcv.received, r.Header.Get(webhook.EventIDHeader))
+	rcv.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rcv *testReceiver) count() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return len(rcv.received)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookDispatcherSlowEndpoint(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	slow := &testReceiver{secret: []byte("slow-secret"), hold: make(chan struct{})}
+	fast := &testReceiver{secret: []byte("fast-secret")}
+	slowServer := httptest.NewServer(slow)
+	defer slowServer.Close()
+	fastServer := httptest.NewServer(fast)
+	defer fastServer.Close()
+
+	for _, endpoint := range []struct {
+		url    string
+		secret []byte
+	}{{slowServer.URL, slow.secret}, {fastServer.URL, fast.secret}} {
+		if _, err := db.CreateWebhookEndpoint(0, endpoint.url, string(endpoint.secret), []string{eventChirpCreated}); err != nil {
+			t.Fatalf("CreateWebhookEndpoint: %v", err)
+		}
+	}
+	const events = 5
+	for i := 0; i < events; i++ {
+		if _, err := db.EnqueueWebhookEvent("evt_"+string(rune('a'+i)), eventChirpCreated, 1, []byte(`{}`)); err != nil {
+			t.Fatalf("EnqueueWebhookEvent: %v", err)
+		}
+	}
+
+	sender := &webhook.Sender{Client: webhook.NewClient(5*time.Second, true)}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	d := newWebhookDispatcher(db, sender, logger, newServerMetrics().webhookDeliveries, 24*time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The fast endpoint gets everything while the slow one holds on to
+	// its first attempts
+	waitFor(t, "deliveries to the fast endpoint", func() bool { return fast.count() == events })
+	if n := slow.count(); n != 0 {
+		t.Errorf("slow endpoint answered %d deliveries while holding", n)
+	}
+
+	close(slow.hold)
+	waitFor(t, "deliveries to the slow endpoint", func() bool { return slow.count() == events })
+
+	slow.mu.Lock()
+	maxInFlight := slow.maxInFlight
+	slow.mu.Unlock()
+	if maxInFlight > d.perEndpoint {
+		t.Errorf("slow endpoint had %d deliveries in flight, want at most %d", maxInFlight, d.perEndpoint)
+	}
+	waitFor(t, "outcomes to be recorded", func() bool { return d.outcomes.With("delivered").Value() == 2*events })
+}